@@ -0,0 +1,43 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/joiningdata/recongo/model"
+)
+
+// explainQuery runs a single query given in the "query" parameter, either
+// as a JSON query object or plain text, and responds with every candidate
+// considered along with how it was found, scored, kept or dropped.
+func (s *Service) explainQuery(w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
+	qtext := r.FormValue("query")
+	if qtext == "" {
+		http.Error(w, "missing query parameter", http.StatusBadRequest)
+		return
+	}
+	q := &model.QueryRequest{Text: qtext}
+	if strings.HasPrefix(strings.TrimSpace(qtext), "{") {
+		q = &model.QueryRequest{}
+		err := json.Unmarshal([]byte(qtext), q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.queryTimeout)
+	defer cancel()
+	ex, err := model.Explain(ctx, src, q)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handleJSONP(w, r, ex)
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/joiningdata/recongo/model"
+)
+
+func TestExplainQuery(t *testing.T) {
+	s := NewService("http://localhost", "/api", testSource(t, "genes",
+		"672\tBRCA1\tgene\t{\"aliases\":[\"IRIS\"]}",
+		"7157\tTP53\tgene\t{}",
+	))
+	tests := []struct {
+		name  string
+		query string
+		code  int
+		kept  []model.EntityID
+	}{
+		{"text", "TP53", http.StatusOK, []model.EntityID{"gene:7157"}},
+		{"alias", "iris", http.StatusOK, []model.EntityID{"gene:672"}},
+		{"json", `{"query": "BRCA1", "limit": 1}`, http.StatusOK, []model.EntityID{"gene:672"}},
+		{"json type", ` {"query": "TP53", "type": "protein"}`, http.StatusOK, nil},
+		{"no candidates", "QXZ1", http.StatusOK, nil},
+		{"missing", "", http.StatusBadRequest, nil},
+		{"invalid json", `{"query": `, http.StatusBadRequest, nil},
+		{"unknown setting", `{"query": "TP53", "limit": "ten"}`, http.StatusBadRequest, nil},
+	}
+	for _, tc := range tests {
+		var ex model.Explanation
+		code := getJSON(t, s, "/api/explain?query="+url.QueryEscape(tc.query), &ex)
+		if code != tc.code {
+			t.Errorf("%s: status = %d, want %d", tc.name, code, tc.code)
+			continue
+		}
+		if code != http.StatusOK {
+			continue
+		}
+		if len(ex.Steps) == 0 {
+			t.Errorf("%s: no steps", tc.name)
+		}
+		var kept []model.EntityID
+		for _, ec := range ex.Candidates {
+			if ec.Kept {
+				kept = append(kept, ec.ID)
+			} else if ec.Reason == "" {
+				t.Errorf("%s: %s was dropped without a reason", tc.name, ec.ID)
+			}
+		}
+		if len(kept) != len(tc.kept) || (len(kept) > 0 && kept[0] != tc.kept[0]) {
+			t.Errorf("%s: kept = %v, want %v", tc.name, kept, tc.kept)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/joiningdata/recongo/model"
+)
+
+// Index serves several reconciliation services from one server, along
+// with a root endpoint listing all of the mounted services.
+type Index struct {
+	// embedded servemux allows Index to act as one also
+	*http.ServeMux
+
+	urlRoot  string
+	prefixes []string
+	services []*Service
+
+	// root is the only service when it is mounted at "/"
+	root *Service
+}
+
+// IndexEntry describes a reconciliation service mounted in an Index.
+type IndexEntry struct {
+	// Name of the service.
+	Name string `json:"name"`
+
+	// Prefix is the URL prefix the service is mounted at.
+	Prefix string `json:"prefix"`
+
+	// ManifestURL is the URL of the service manifest.
+	ManifestURL string `json:"manifest"`
+}
+
+// NewIndex returns an empty Index bound to the specified url.
+func NewIndex(urlRoot string) *Index {
+	x := &Index{
+		ServeMux: http.NewServeMux(),
+		urlRoot:  urlRoot,
+	}
+	x.HandleFunc("/", x.listServices)
+	return x
+}
+
+// Mount creates a new Service for the data source and serves it from the
+// prefix, which must start with "/" and be unique. A service mounted at "/"
+// replaces the list of services, so it must be the only one.
+func (x *Index) Mount(prefix string, src model.Source) (*Service, error) {
+	switch {
+	case !strings.HasPrefix(prefix, "/"):
+		return nil, fmt.Errorf("recongo.api: prefix '%s' must start with /", prefix)
+	case prefix != "/" && strings.HasSuffix(prefix, "/"):
+		return nil, fmt.Errorf("recongo.api: prefix '%s' must not end with /", prefix)
+	case x.root != nil || (prefix == "/" && len(x.services) > 0):
+		return nil, fmt.Errorf("recongo.api: prefix / can't be shared with other services")
+	}
+	for _, p := range x.prefixes {
+		if p == prefix {
+			return nil, fmt.Errorf("recongo.api: prefix '%s' is already mounted", prefix)
+		}
+	}
+
+	var s *Service
+	if prefix == "/" {
+		s = NewService(x.urlRoot, "", src)
+		x.root = s
+	} else {
+		s = NewService(x.urlRoot, prefix, src)
+		x.Handle(prefix, s)
+		x.Handle(prefix+"/", s)
+	}
+	x.prefixes = append(x.prefixes, prefix)
+	x.services = append(x.services, s)
+	return s, nil
+}
+
+// lists all the mounted services
+func (x *Index) listServices(w http.ResponseWriter, r *http.Request) {
+	if x.root != nil {
+		x.root.ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	entries := make([]*IndexEntry, len(x.services))
+	for i, s := range x.services {
+		entries[i] = &IndexEntry{
+			Name:        s.Manifest().Name,
+			Prefix:      x.prefixes[i],
+			ManifestURL: x.urlRoot + x.prefixes[i],
+		}
+	}
+	handleJSONP(w, r, map[string]interface{}{"services": entries})
+}
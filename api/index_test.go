@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// getJSON serves a GET request and decodes the JSON response.
+func getJSON(t *testing.T, h http.Handler, path string, v interface{}) int {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: %v: %s", path, err, w.Body)
+		}
+	}
+	return w.Code
+}
+
+func TestIndexMount(t *testing.T) {
+	x := NewIndex("http://localhost")
+	for _, prefix := range []string{"/genes", "/proteins"} {
+		if _, err := x.Mount(prefix, testSource(t, prefix[1:])); err != nil {
+			t.Fatalf("Mount(%s): %v", prefix, err)
+		}
+	}
+
+	var list struct {
+		Services []*IndexEntry `json:"services"`
+	}
+	if code := getJSON(t, x, "/", &list); code != http.StatusOK || len(list.Services) != 2 {
+		t.Fatalf("GET / = %d, %v, want 2 services", code, list.Services)
+	}
+	if e := list.Services[1]; e.Name != "proteins" || e.ManifestURL != "http://localhost/proteins" {
+		t.Errorf("services[1] = %+v", e)
+	}
+	var m Manifest
+	if code := getJSON(t, x, "/genes", &m); code != http.StatusOK || m.Name != "genes" {
+		t.Errorf("GET /genes = %d, %q, want the genes manifest", code, m.Name)
+	}
+
+	tests := []struct {
+		prefix string
+		err    string
+	}{
+		{"", "must start with /"},
+		{"genes", "must start with /"},
+		{"/genes/", "must not end with /"},
+		{"/genes", "already mounted"},
+		{"/", "can't be shared"},
+	}
+	for _, tc := range tests {
+		_, err := x.Mount(tc.prefix, testSource(t, "test"))
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("Mount(%q) error = %v, want %q", tc.prefix, err, tc.err)
+		}
+	}
+}
+
+// A single service mounted at "/" serves its manifest and endpoints from
+// the root, instead of the list of services.
+func TestIndexMountRoot(t *testing.T) {
+	x := NewIndex("http://localhost")
+	s, err := x.Mount("/", testSource(t, "genes", "672\tBRCA1\tgene\t{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m Manifest
+	if code := getJSON(t, x, "/", &m); code != http.StatusOK || m.Name != "genes" {
+		t.Errorf("GET / = %d, %q, want the genes manifest", code, m.Name)
+	}
+	if m.Preview == nil || m.Preview.URL != "http://localhost/preview/%s" {
+		t.Errorf("manifest preview = %+v", m.Preview)
+	}
+	w := httptest.NewRecorder()
+	x.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/preview/gene:672", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "BRCA1") {
+		t.Errorf("GET /preview/gene:672 = %d: %s", w.Code, w.Body)
+	}
+	var props map[string]interface{}
+	if code := getJSON(t, x, "/properties?type=gene", &props); code != http.StatusOK {
+		t.Errorf("GET /properties = %d", code)
+	}
+	if s.Manifest().Name != "genes" {
+		t.Errorf("Manifest().Name = %q", s.Manifest().Name)
+	}
+
+	if _, err = x.Mount("/api", testSource(t, "test")); err == nil {
+		t.Error("Mount(/api) after / did not fail")
+	}
+}
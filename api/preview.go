@@ -0,0 +1,129 @@
+package api
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/joiningdata/recongo/model"
+)
+
+// maximum number of properties shown in a preview card
+const maxPreviewProperties = 8
+
+// defaultPreviewTemplate renders an entity as a small HTML card.
+var defaultPreviewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>{{.Entity.Name}}</title>
+<style>
+body{font-family:sans-serif;font-size:12px;margin:6px;}
+h1{font-size:14px;margin:0 0 4px 0;}
+.types{color:#666;}
+table{border-collapse:collapse;margin-top:4px;}
+th{text-align:left;padding-right:8px;color:#444;}
+</style></head>
+<body>
+<h1>{{if .ViewURL}}<a href="{{.ViewURL}}" target="_blank">{{.Entity.Name}}</a>{{else}}{{.Entity.Name}}{{end}}</h1>
+<div class="types">{{.Entity.ID}}{{range .Entity.Types}} &middot; {{.Name}}{{end}}</div>
+{{if .Entity.Description}}<p>{{.Entity.Description}}</p>{{end}}
+{{if .Properties}}<table>{{range .Properties}}
+<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>{{end}}
+</table>{{end}}
+</body></html>
+`))
+
+// PreviewData is the data available to preview templates.
+type PreviewData struct {
+	// Entity being previewed.
+	Entity *model.Entity
+
+	// ViewURL is the permalink for the Entity (if available).
+	ViewURL string
+
+	// Properties lists the first few property values of the Entity.
+	Properties []*PreviewProperty
+}
+
+// PreviewProperty is a property value shown in a preview.
+type PreviewProperty struct {
+	// Name of the property.
+	Name string
+
+	// Value of the property.
+	Value string
+}
+
+// SetPreviewTemplate replaces the default entity preview with the html
+// template in filename, which is executed with a PreviewData.
+func (s *Service) SetPreviewTemplate(filename string) error {
+	t, err := template.ParseFiles(filename)
+	if err != nil {
+		return err
+	}
+	s.preview = t
+	return nil
+}
+
+func (s *Service) previewEntity(w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
+	eid := model.EntityID(r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:])
+	if id := r.URL.Query().Get("id"); id != "" {
+		eid = model.EntityID(id)
+	}
+	e, err := src.GetEntityContext(r.Context(), eid)
+	if err == model.ErrNotFound {
+		http.Error(w, "entity not found: "+string(eid), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	data := &PreviewData{Entity: e}
+	for _, t := range e.Types {
+		if t != nil && t.ID == eid.Type() && t.ViewURL != "" {
+			data.ViewURL = URLTemplate(t.ViewURL).Apply(eid.ID())
+			break
+		}
+	}
+
+	names := make(map[string]string)
+	for _, p := range src.Properties(eid.Type()) {
+		names[p.ID] = p.Name
+	}
+	for pid, val := range e.Properties {
+		if pid == "description" {
+			continue
+		}
+		name, ok := names[pid]
+		if !ok {
+			name = pid
+		}
+		var vals []string
+		for _, v := range model.PropertyValues(val) {
+			vals = append(vals, fmt.Sprint(v))
+		}
+		data.Properties = append(data.Properties, &PreviewProperty{
+			Name:  name,
+			Value: strings.Join(vals, ", "),
+		})
+	}
+	sort.Slice(data.Properties, func(i, j int) bool {
+		return data.Properties[i].Name < data.Properties[j].Name
+	})
+	if len(data.Properties) > maxPreviewProperties {
+		data.Properties = data.Properties[:maxPreviewProperties]
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = s.preview.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+	}
+}
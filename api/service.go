@@ -1,13 +1,16 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/joiningdata/recongo/model"
@@ -18,8 +21,42 @@ type Service struct {
 	// embedded servemux allows Service to act as one also
 	*http.ServeMux
 
+	urlRoot string
+	prefix  string
+	preview *template.Template
+
+	// guards the data source and manifest, which can be replaced
+	mu       sync.RWMutex
 	manifest *Manifest
-	source   model.Source
+	source   *servedSource
+
+	// maximum number of queries in a batch to run concurrently.
+	concurrency int
+
+	// maximum time to wait for each query of a batch.
+	queryTimeout time.Duration
+}
+
+const (
+	// DefaultConcurrency is the default number of batch queries run concurrently.
+	DefaultConcurrency = 4
+
+	// DefaultQueryTimeout is the default time to wait for each query of a batch.
+	DefaultQueryTimeout = 30 * time.Second
+)
+
+// SetQueryLimits sets the maximum number of queries in a batch to run
+// concurrently, and the time to wait for each one before giving up.
+// Values <= 0 restore the defaults.
+func (s *Service) SetQueryLimits(concurrency int, timeout time.Duration) {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	s.concurrency = concurrency
+	s.queryTimeout = timeout
 }
 
 // helper to package JSON response (optional JSONP) content with CORS header
@@ -43,15 +80,24 @@ func handleJSONP(w http.ResponseWriter, r *http.Request, payload interface{}) {
 }
 
 func (s *Service) suggestEntity(w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
 	prefix := r.URL.Query().Get("prefix")
-	results := s.source.QueryPrefix(prefix, 25)
+	results, err := src.QueryPrefixContext(r.Context(), prefix, 25)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	handleJSONP(w, r, map[string]interface{}{"result": results})
 }
 
 func (s *Service) suggestType(w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
 	prefix := r.URL.Query().Get("prefix")
 	low := strings.ToLower(prefix)
-	types := s.source.Types()
+	types := src.Types()
 	results := []*model.Type{}
 	for _, t := range types {
 		if strings.HasPrefix(strings.ToLower(t.Name), low) {
@@ -70,13 +116,15 @@ func (s *Service) suggestType(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Service) suggestProps(w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
 	prefix := r.URL.Query().Get("prefix")
 	low := strings.ToLower(prefix)
 	hits := make(map[string]*model.Property)
 
-	types := s.source.Types()
+	types := src.Types()
 	for _, t := range types {
-		props := s.source.Properties(t.ID)
+		props := src.Properties(t.ID)
 		for _, p := range props {
 			if _, ok := hits[p.ID]; ok {
 				continue
@@ -89,7 +137,7 @@ func (s *Service) suggestProps(w http.ResponseWriter, r *http.Request) {
 	if len(hits) == 0 {
 		// if there are 0 actual-prefix hits, try harder to be nice
 		for _, t := range types {
-			props := s.source.Properties(t.ID)
+			props := src.Properties(t.ID)
 			for _, p := range props {
 				if _, ok := hits[p.ID]; ok {
 					continue
@@ -109,6 +157,8 @@ func (s *Service) suggestProps(w http.ResponseWriter, r *http.Request) {
 
 // lists properties of a specific Entity Type
 func (s *Service) listProperties(w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
 	resp := struct {
 		Limit      int               `json:"limit"`
 		Type       string            `json:"type"`
@@ -122,7 +172,7 @@ func (s *Service) listProperties(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	resp.Properties = s.source.Properties(resp.Type)
+	resp.Properties = src.Properties(resp.Type)
 	if resp.Limit > 0 && len(resp.Properties) > resp.Limit {
 		resp.Properties = resp.Properties[:resp.Limit]
 	}
@@ -177,48 +227,108 @@ func (s *Service) reconHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// no 'queries' or 'extend' in GET or POST, send the manifest instead
-	handleJSONP(w, r, s.manifest)
+	handleJSONP(w, r, s.Manifest())
+}
+
+// ExtendMeta describes a property included in an extend response.
+type ExtendMeta struct {
+	// ID of the property.
+	ID string `json:"id"`
+
+	// Name of the property.
+	Name string `json:"name"`
+
+	// Type of the entities referenced by the property values (if any).
+	Type *model.Type `json:"type,omitempty"`
 }
 
 func (s *Service) extendResult(extend *ExtendRequest, w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
 	//  the response type for ExtendRequest.
 	var resp = struct {
 		// Meta describes the properties included in this response.
-		Meta []*model.Property `json:"meta"`
+		Meta []*ExtendMeta `json:"meta"`
 
 		// Rows maps [Entity ID] to [Property ID] to list of value-maps
-		Rows map[model.EntityID]map[string][]map[string]string `json:"rows"`
-	}{Rows: make(map[model.EntityID]map[string][]map[string]string)}
+		Rows map[model.EntityID]map[string][]map[string]interface{} `json:"rows"`
+	}{Rows: make(map[model.EntityID]map[string][]map[string]interface{})}
 
 	propReq := make(map[string]*ExtendProperty)
 	for _, r := range extend.Properties {
 		propReq[r.ID] = r
 	}
 
+	types := make(map[string]*model.Type)
+	for _, t := range src.Types() {
+		types[t.ID] = t
+	}
+
 	for _, entityID := range extend.IDs {
-		e, ok := s.source.GetEntity(entityID)
-		if !ok {
+		e, err := src.GetEntityContext(r.Context(), entityID)
+		if err == model.ErrNotFound {
 			http.Error(w, "entity not found: "+string(entityID), http.StatusNotFound)
 			return
 		}
+		if err != nil {
+			log.Println(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
-		rowprops := make(map[string][]map[string]string)
+		propDefs := make(map[string]*model.Property)
+		for _, p := range src.Properties(entityID.Type()) {
+			propDefs[p.ID] = p
+		}
+
+		rowprops := make(map[string][]map[string]interface{})
 		for pid, val := range e.Properties {
 			if pr, ok := propReq[pid]; ok {
-				if len(pr.Settings) > 0 {
-					// TODO: handle these
+				settings := parseExtendSettings(pr.Settings)
+				valueType := ""
+				if p, ok := propDefs[pid]; ok {
+					valueType = p.ValueType
+				}
+				for _, v := range model.PropertyValues(val) {
+					if settings.limit > 0 && len(rowprops[pid]) >= settings.limit {
+						break
+					}
+					ev := s.extendValue(r.Context(), src, valueType, types, v)
+					if settings.content == "literal" {
+						if name, ok := ev["name"]; ok {
+							// entity reference, return its name instead
+							ev = map[string]interface{}{"str": name}
+						}
+					}
+					rowprops[pid] = append(rowprops[pid], ev)
 				}
-				valmap := map[string]string{"str": fmt.Sprint(val)}
-				rowprops[pid] = append(rowprops[pid], valmap)
 			}
 		}
 		resp.Rows[entityID] = rowprops
 
 		if len(propReq) > len(resp.Meta) {
-			allProps := s.source.Properties(entityID.Type())
-			for _, p := range allProps {
-				if _, ok := propReq[p.ID]; ok {
-					resp.Meta = append(resp.Meta, p)
+			for _, pr := range extend.Properties {
+				p, ok := propDefs[pr.ID]
+				if !ok {
+					continue
+				}
+				found := false
+				for _, m := range resp.Meta {
+					if m.ID == p.ID {
+						found = true
+						break
+					}
+				}
+				if !found {
+					m := &ExtendMeta{
+						ID:   p.ID,
+						Name: p.Name,
+						Type: types[p.ValueType],
+					}
+					if parseExtendSettings(pr.Settings).content == "literal" {
+						m.Type = nil
+					}
+					resp.Meta = append(resp.Meta, m)
 				}
 			}
 		}
@@ -226,31 +336,213 @@ func (s *Service) extendResult(extend *ExtendRequest, w http.ResponseWriter, r *
 	handleJSONP(w, r, resp)
 }
 
+// extendPropertySettings lists the settings supported for requested properties.
+var extendPropertySettings = []*PropertySetting{
+	{
+		Name:     "limit",
+		Label:    "Limit",
+		HelpText: "Maximum number of values to return per row (0 for no limit)",
+		Default:  "0",
+		Type:     "number",
+	},
+	{
+		Name:     "content",
+		Label:    "Content",
+		HelpText: "Content type for values that refer to other entities",
+		Default:  "id",
+		Type:     "select",
+		Choices: []*PropertyChoice{
+			{Name: "Identifier", Value: "id"},
+			{Name: "Literal", Value: "literal"},
+		},
+	},
+	{
+		// accepted for clients that always send it, but data sources do not
+		// have language-tagged values, so every value is returned as is.
+		Name:     "language",
+		Label:    "Language",
+		HelpText: "Language of the values to return (values are not language-tagged, so all are returned)",
+		Default:  "",
+		Type:     "text",
+	},
+}
+
+// extendSettings are the parsed settings for a requested property.
+type extendSettings struct {
+	// limit is the maximum number of values to return (0 for no limit).
+	limit int
+
+	// content is "id" or "literal" for entity-valued properties.
+	content string
+}
+
+// parseExtendSettings parses the settings of a requested property,
+// ignoring any invalid or unknown settings. The language setting has
+// no effect (see extendPropertySettings).
+func parseExtendSettings(settings map[string]interface{}) extendSettings {
+	res := extendSettings{content: "id"}
+	switch x := settings["limit"].(type) {
+	case float64:
+		res.limit = int(x)
+	case string:
+		if n, err := strconv.Atoi(x); err == nil {
+			res.limit = n
+		}
+	}
+	if c, ok := settings["content"].(string); ok && c == "literal" {
+		res.content = c
+	}
+	return res
+}
+
+// parseBool returns the boolean value of a property value, and false
+// if it is not a bool, a number, or one of the strings recognized by
+// model.PropertyValue.Bool and their opposites.
+func parseBool(val interface{}) (bool, bool) {
+	switch x := val.(type) {
+	case bool, int64, float64:
+		return model.NewPropertyValue(x).Bool(), true
+	case string:
+		switch strings.ToUpper(x) {
+		case "YES", "TRUE", "T", "ON", "1":
+			return true, true
+		case "NO", "FALSE", "F", "OFF", "0":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// date layouts recognized in property values
+var dateLayouts = []string{time.RFC3339, "2006-01-02", "20060102", "2006/01/02", "2006-01", "2006"}
+
+// extendValue converts a property value into an extend protocol value,
+// according to the property value type. Values that cannot be converted
+// are returned as strings.
+func (s *Service) extendValue(ctx context.Context, src model.ContextSource, valueType string, types map[string]*model.Type, val interface{}) map[string]interface{} {
+	pv := model.NewPropertyValue(val)
+	str := pv.String()
+	switch valueType {
+	case "", model.ValueString:
+	case model.ValueInt:
+		if n, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return map[string]interface{}{"int": n}
+		}
+	case model.ValueFloat:
+		if n, err := strconv.ParseFloat(str, 64); err == nil {
+			return map[string]interface{}{"float": n}
+		}
+	case model.ValueBool:
+		if b, ok := parseBool(val); ok {
+			return map[string]interface{}{"bool": b}
+		}
+	case model.ValueDate:
+		for _, layout := range dateLayouts {
+			if d, err := time.Parse(layout, str); err == nil {
+				return map[string]interface{}{"date": d.Format(time.RFC3339)}
+			}
+		}
+	default:
+		if _, ok := types[valueType]; ok {
+			// reference to another entity
+			eid := model.EntityID(str)
+			if eid.Type() == "" {
+				eid = model.EntityID(valueType + ":" + str)
+			}
+			ref := map[string]interface{}{"id": eid, "name": eid.ID()}
+			if e, err := src.GetEntityContext(ctx, eid); err == nil {
+				ref["name"] = e.Name
+			}
+			return ref
+		}
+	}
+	return map[string]interface{}{"str": str}
+}
+
 func (s *Service) queryResult(queries map[string]*model.QueryRequest, w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
 	// collect results for each query
 	type ResultSet struct {
 		R []*model.Candidate `json:"result"`
+
+		// Error describes why the query failed (if it did).
+		Error string `json:"error,omitempty"`
 	}
-	results := make(map[string]*ResultSet, len(queries))
-	for qid, q := range queries {
-		q.ID = qid
-		resp, err := s.source.Query(q)
-		if err != nil {
-			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+	type queryDone struct {
+		qid string
+		rs  *ResultSet
+	}
+
+	// feed the queries to a bounded pool of workers
+	todo := make(chan *model.QueryRequest)
+	done := make(chan queryDone)
+	nworkers := s.concurrency
+	if nworkers > len(queries) {
+		nworkers = len(queries)
+	}
+	for i := 0; i < nworkers; i++ {
+		go func() {
+			for q := range todo {
+				ctx, cancel := context.WithTimeout(r.Context(), s.queryTimeout)
+				resp, err := s.runQuery(ctx, src, q)
+				cancel()
+				if err != nil {
+					log.Println(q.ID, err)
+					done <- queryDone{q.ID, &ResultSet{R: []*model.Candidate{}, Error: err.Error()}}
+					continue
+				}
+				done <- queryDone{q.ID, &ResultSet{R: resp.Results}}
+			}
+		}()
+	}
+	go func() {
+		for qid, q := range queries {
+			q.ID = qid
+			todo <- q
 		}
-		results[resp.ID] = &ResultSet{R: resp.Results}
+		close(todo)
+	}()
+
+	results := make(map[string]*ResultSet, len(queries))
+	for range queries {
+		qd := <-done
+		results[qd.qid] = qd.rs
 	}
 
 	handleJSONP(w, r, results)
 }
 
+// runQuery runs a single query, giving up when the context is done
+// even if the source cannot interrupt the query itself. The source is
+// held until the query returns, even after giving up on it.
+func (s *Service) runQuery(ctx context.Context, src *servedSource, q *model.QueryRequest) (*model.QueryResponse, error) {
+	type queryResp struct {
+		resp *model.QueryResponse
+		err  error
+	}
+	ch := make(chan queryResp, 1)
+	src.users.Add(1)
+	go func() {
+		defer src.release()
+		resp, err := src.QueryContext(ctx, q)
+		ch <- queryResp{resp, err}
+	}()
+	select {
+	case qr := <-ch:
+		return qr.resp, qr.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
 func (s *Service) viewEntity(w http.ResponseWriter, r *http.Request) {
+	src := s.acquire()
+	defer src.release()
 	log.Println(r.URL.Path)
 
 	eid := model.EntityID(r.URL.Path[strings.LastIndexByte(r.URL.Path, '/')+1:])
-	types := s.source.Types()
+	types := src.Types()
 	var realURL URLTemplate
 	for _, t := range types {
 		if t.ID == eid.Type() {
@@ -263,8 +555,86 @@ func (s *Service) viewEntity(w http.ResponseWriter, r *http.Request) {
 
 // NewService returns a new service provider bound to the specified url and
 // prefix, which serves reconciliation request for the given data source.
+// A blank prefix serves reconciliation requests from the root "/".
 func NewService(urlRoot, prefix string, src model.Source) *Service {
+	s := &Service{
+		ServeMux: http.NewServeMux(),
+		urlRoot:  urlRoot,
+		prefix:   prefix,
+		preview:  defaultPreviewTemplate,
+
+		concurrency:  DefaultConcurrency,
+		queryTimeout: DefaultQueryTimeout,
+	}
+	s.SetSource(src)
 
+	if prefix == "" {
+		s.HandleFunc("/", s.reconHandler)
+	} else {
+		s.HandleFunc(prefix, s.reconHandler)
+	}
+	s.HandleFunc(prefix+"/auto/entities", s.suggestEntity)
+	s.HandleFunc(prefix+"/auto/types", s.suggestType)
+	s.HandleFunc(prefix+"/auto/properties", s.suggestProps)
+	s.HandleFunc(prefix+"/properties", s.listProperties)
+	s.HandleFunc(prefix+"/view/", s.viewEntity)
+	s.HandleFunc(prefix+"/preview/", s.previewEntity)
+	s.HandleFunc(prefix+"/explain", s.explainQuery)
+	return s
+}
+
+// servedSource is a data source being served, with a count of the
+// requests and queries still using it, so that a replaced source is
+// only closed once they have finished.
+type servedSource struct {
+	model.ContextSource
+	raw   model.Source
+	users sync.WaitGroup
+}
+
+// release marks that a user of the source has finished with it.
+func (ss *servedSource) release() {
+	ss.users.Done()
+}
+
+// SetSource replaces the data source being served, and returns the
+// previous one (if any) along with a function that waits until the
+// requests still using it have finished.
+func (s *Service) SetSource(src model.Source) (model.Source, func()) {
+	m := newManifest(s.urlRoot, s.prefix, src)
+
+	s.mu.Lock()
+	old := s.source
+	s.manifest = m
+	s.source = &servedSource{
+		ContextSource: model.WithContext(src),
+		raw:           src,
+	}
+	s.mu.Unlock()
+	if old == nil {
+		return nil, func() {}
+	}
+	return old.raw, old.users.Wait
+}
+
+// acquire returns the data source currently being served, which the
+// caller must release when it has finished with it.
+func (s *Service) acquire() *servedSource {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	s.source.users.Add(1)
+	return s.source
+}
+
+// Manifest returns the manifest for the data source currently being served.
+func (s *Service) Manifest() *Manifest {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.manifest
+}
+
+// newManifest describes the service for a data source.
+func newManifest(urlRoot, prefix string, src model.Source) *Manifest {
 	m := &Manifest{
 		Versions:        []string{"0.1", "0.2"},
 		Name:            src.Name(),
@@ -292,7 +662,13 @@ func NewService(urlRoot, prefix string, src model.Source) *Service {
 				ServiceURL:  urlRoot + prefix,
 				ServicePath: "/properties",
 			},
-			//PropertySettings: []*PropertySetting{},
+			PropertySettings: extendPropertySettings,
+		},
+
+		Preview: &Preview{
+			Width:  400,
+			Height: 200,
+			URL:    URLTemplate(urlRoot + prefix + "/preview/%s"),
 		},
 	}
 
@@ -301,17 +677,5 @@ func NewService(urlRoot, prefix string, src model.Source) *Service {
 			URL: URLTemplate(urlRoot + prefix + "/view/%s"),
 		}
 	}
-
-	s := &Service{
-		ServeMux: http.NewServeMux(),
-		manifest: m,
-		source:   src,
-	}
-	s.HandleFunc(prefix, s.reconHandler)
-	s.HandleFunc(prefix+"/auto/entities", s.suggestEntity)
-	s.HandleFunc(prefix+"/auto/types", s.suggestType)
-	s.HandleFunc(prefix+"/auto/properties", s.suggestProps)
-	s.HandleFunc(prefix+"/properties", s.listProperties)
-	s.HandleFunc(prefix+"/view/", s.viewEntity)
-	return s
+	return m
 }
@@ -0,0 +1,257 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joiningdata/recongo/model"
+)
+
+// testSource loads a flat file data source of genes with the given
+// entity rows (ID, name, types and properties, tab-separated).
+func testSource(t *testing.T, name string, rows ...string) model.Source {
+	f, err := ioutil.TempFile("", "recongo.*.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, "ncbi\t%s\tncbi\t[{\"id\":\"gene\",\"name\":\"Gene\"}]\n", name)
+	for _, row := range rows {
+		fmt.Fprintln(f, row)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	src, err := model.Load(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+// A replaced source is idle only once the requests using it release it.
+func TestSetSourceWaitsForRequests(t *testing.T) {
+	first := testSource(t, "first")
+	s := NewService("http://localhost", "/api", first)
+
+	req := s.acquire()
+	old, idle := s.SetSource(testSource(t, "second"))
+	if old != first {
+		t.Fatalf("SetSource returned %v, want the first source", old)
+	}
+	if s.acquire() == req {
+		t.Fatal("new requests still use the replaced source")
+	}
+
+	waited := make(chan struct{})
+	go func() {
+		idle()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("replaced source is idle while a request still uses it")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	req.release()
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("replaced source is not idle after the request released it")
+	}
+}
+
+func TestParseExtendSettings(t *testing.T) {
+	tests := []struct {
+		settings map[string]interface{}
+		want     extendSettings
+	}{
+		{nil, extendSettings{content: "id"}},
+		{map[string]interface{}{"limit": 3.0}, extendSettings{limit: 3, content: "id"}},
+		{map[string]interface{}{"limit": "2"}, extendSettings{limit: 2, content: "id"}},
+		{map[string]interface{}{"limit": "two"}, extendSettings{content: "id"}},
+		{map[string]interface{}{"content": "literal"}, extendSettings{content: "literal"}},
+		{map[string]interface{}{"content": "other"}, extendSettings{content: "id"}},
+		// accepted, but values are not language-tagged
+		{map[string]interface{}{"language": "fr"}, extendSettings{content: "id"}},
+	}
+	for _, tc := range tests {
+		if got := parseExtendSettings(tc.settings); got != tc.want {
+			t.Errorf("parseExtendSettings(%v) = %+v, want %+v", tc.settings, got, tc.want)
+		}
+	}
+
+	var names []string
+	for _, ps := range extendPropertySettings {
+		names = append(names, ps.Name)
+	}
+	if want := []string{"limit", "content", "language"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("property settings = %v, want %v", names, want)
+	}
+}
+
+func TestExtendValueBool(t *testing.T) {
+	s := NewService("http://localhost", "/api", testSource(t, "test"))
+	tests := []struct {
+		val  interface{}
+		want map[string]interface{}
+	}{
+		{true, map[string]interface{}{"bool": true}},
+		{int64(0), map[string]interface{}{"bool": false}},
+		{"yes", map[string]interface{}{"bool": true}},
+		{"T", map[string]interface{}{"bool": true}},
+		{"off", map[string]interface{}{"bool": false}},
+		{"0", map[string]interface{}{"bool": false}},
+		// values that are not booleans are kept as strings
+		{"unknown", map[string]interface{}{"str": "unknown"}},
+		{"", map[string]interface{}{"str": ""}},
+	}
+	src := s.acquire()
+	defer src.release()
+	for _, tc := range tests {
+		got := s.extendValue(context.Background(), src, model.ValueBool, nil, tc.val)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("extendValue(%v) = %v, want %v", tc.val, got, tc.want)
+		}
+	}
+}
+
+// slowSource answers queries by their text: "fail" returns an error,
+// "slow" waits for the query's context, and "stuck" ignores it and
+// waits for unstick. Other queries are answered by the source embedded.
+type slowSource struct {
+	model.Source
+	unstick chan struct{}
+}
+
+func (s *slowSource) GetEntityContext(ctx context.Context, id model.EntityID) (*model.Entity, error) {
+	return model.WithContext(s.Source).GetEntityContext(ctx, id)
+}
+
+func (s *slowSource) QueryPrefixContext(ctx context.Context, text string, limit int) ([]*model.Entity, error) {
+	return model.WithContext(s.Source).QueryPrefixContext(ctx, text, limit)
+}
+
+func (s *slowSource) QueryContext(ctx context.Context, q *model.QueryRequest) (*model.QueryResponse, error) {
+	switch q.Text {
+	case "fail":
+		return nil, errors.New("query failed")
+	case "slow":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case "stuck":
+		<-s.unstick
+		return &model.QueryResponse{ID: q.ID}, nil
+	}
+	return model.WithContext(s.Source).QueryContext(ctx, q)
+}
+
+func TestQueryResultErrors(t *testing.T) {
+	src := &slowSource{
+		Source: testSource(t, "test",
+			"672\tBRCA1\tgene\t{}", "7157\tTP53\tgene\t{}"),
+		unstick: make(chan struct{}),
+	}
+	defer close(src.unstick)
+	s := NewService("http://localhost", "/api", src)
+	s.SetQueryLimits(2, 50*time.Millisecond)
+
+	queries := `{"q0": {"query": "BRCA1"}, "q1": {"query": "fail"}, "q2": {"query": "slow"},
+		"q3": {"query": "stuck"}, "q4": {"query": "TP53"}}`
+	form := url.Values{"queries": {queries}}
+	req := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+
+	start := time.Now()
+	s.ServeHTTP(w, req)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("batch took %s, want it to give up on stuck queries", elapsed)
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	var resp map[string]struct {
+		Result []*model.Candidate `json:"result"`
+		Error  string             `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		qid, top, err string
+	}{
+		{"q0", "gene:672", ""},
+		{"q1", "", "query failed"},
+		{"q2", "", context.DeadlineExceeded.Error()},
+		{"q3", "", context.DeadlineExceeded.Error()},
+		{"q4", "gene:7157", ""},
+	}
+	for _, tc := range tests {
+		rs, ok := resp[tc.qid]
+		if !ok {
+			t.Errorf("%s: missing from the response", tc.qid)
+			continue
+		}
+		if rs.Error != tc.err {
+			t.Errorf("%s: error = %q, want %q", tc.qid, rs.Error, tc.err)
+		}
+		if tc.top == "" {
+			if rs.Result == nil || len(rs.Result) != 0 {
+				t.Errorf("%s: result = %v, want an empty list", tc.qid, rs.Result)
+			}
+		} else if len(rs.Result) == 0 || string(rs.Result[0].ID) != tc.top {
+			t.Errorf("%s: result = %v, want %s first", tc.qid, rs.Result, tc.top)
+		}
+	}
+}
+
+// A query that ignores its context still holds the source after the
+// request gives up on it.
+func TestRunQueryHoldsSource(t *testing.T) {
+	src := &slowSource{
+		Source:  testSource(t, "test"),
+		unstick: make(chan struct{}),
+	}
+	s := NewService("http://localhost", "/api", src)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	ss := s.acquire()
+	_, err := s.runQuery(ctx, ss, &model.QueryRequest{Text: "stuck"})
+	ss.release()
+	if err != context.DeadlineExceeded {
+		t.Fatalf("runQuery error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	_, idle := s.SetSource(testSource(t, "new"))
+	waited := make(chan struct{})
+	go func() {
+		idle()
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("replaced source is idle while a query is still running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(src.unstick)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("replaced source is not idle after the query returned")
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// multiValueSep separates the values of a column that has more than one
+// value in a row, e.g. the values of an array in a JSON file.
+const multiValueSep = "\x1f"
+
+// columnMap maps the columns of a data file to Property IDs, resolved
+// against the header of the file.
+type columnMap struct {
+	header []string
+
+	// Type ID of every row (may be blank), and the column of additional
+	// Type IDs for each row (or -1)
+	typeID  string
+	typeCol int
+
+	// column index => Property ID
+	props map[int]string
+	// column index => delimiter for multi-valued columns
+	delims map[int]string
+	// column index => transforms applied to the values
+	transforms map[int]*pipeline
+	// Property ID => computed value
+	computed map[string]*template
+	// rows must pass every filter to be kept
+	filters []*rowFilter
+}
+
+// findColumn returns the index of a column given by its header name, or by
+// its 0-based index if no column has that name.
+func findColumn(header []string, col string) (int, error) {
+	for i, h := range header {
+		if h == col {
+			return i, nil
+		}
+	}
+	i, err := strconv.Atoi(col)
+	if err != nil || i < 0 || i >= len(header) {
+		return -1, fmt.Errorf("column '%s' not found in the header", col)
+	}
+	return i, nil
+}
+
+// configColumns returns the columns used in a file configuration, sorted.
+func configColumns(fc FileConfig) []string {
+	seen := make(map[string]struct{})
+	for col := range fc.Properties {
+		seen[col] = struct{}{}
+	}
+	for col := range fc.Delimiters {
+		seen[col] = struct{}{}
+	}
+	for col := range fc.Transforms {
+		seen[col] = struct{}{}
+	}
+	for _, f := range fc.Filters {
+		seen[f.Column] = struct{}{}
+	}
+	for _, tmpl := range fc.Computed {
+		for _, m := range templateRefs.FindAllStringSubmatch(tmpl, -1) {
+			seen[m[1]] = struct{}{}
+		}
+	}
+	if fc.TypeColumn != "" {
+		seen[fc.TypeColumn] = struct{}{}
+	}
+	res := make([]string, 0, len(seen))
+	for col := range seen {
+		res = append(res, col)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// newColumnMap resolves the columns of a file configuration against the
+// header of the file.
+func newColumnMap(fc FileConfig, header []string) (*columnMap, error) {
+	m := &columnMap{
+		header:     header,
+		typeID:     fc.ID,
+		typeCol:    -1,
+		props:      make(map[int]string),
+		delims:     make(map[int]string),
+		transforms: make(map[int]*pipeline),
+		computed:   make(map[string]*template),
+	}
+	for col, propID := range fc.Properties {
+		i, err := findColumn(header, col)
+		if err != nil {
+			return nil, err
+		}
+		m.props[i] = propID
+	}
+	if fc.TypeColumn != "" {
+		i, err := findColumn(header, fc.TypeColumn)
+		if err != nil {
+			return nil, err
+		}
+		m.typeCol = i
+	}
+	for col, delim := range fc.Delimiters {
+		i, err := findColumn(header, col)
+		if err != nil {
+			return nil, err
+		}
+		m.delims[i] = delim
+	}
+	for col, ts := range fc.Transforms {
+		i, err := findColumn(header, col)
+		if err != nil {
+			return nil, err
+		}
+		p, err := compilePipeline(ts)
+		if err != nil {
+			return nil, fmt.Errorf("column '%s': %v", col, err)
+		}
+		m.transforms[i] = p
+	}
+	for propID, tmpl := range fc.Computed {
+		t, err := parseTemplate(header, tmpl)
+		if err != nil {
+			return nil, fmt.Errorf("computed '%s': %v", propID, err)
+		}
+		m.computed[propID] = t
+	}
+	for _, f := range fc.Filters {
+		rf, err := newRowFilter(f, header)
+		if err != nil {
+			return nil, err
+		}
+		m.filters = append(m.filters, rf)
+	}
+	return m, nil
+}
+
+// unmatched returns the header names of the columns that are not mapped to
+// a property or type, or used by a computed value.
+func (m *columnMap) unmatched() []string {
+	used := make(map[int]bool)
+	for i, propID := range m.props {
+		used[i] = propID != ""
+	}
+	if m.typeCol >= 0 {
+		used[m.typeCol] = true
+	}
+	for _, t := range m.computed {
+		for _, i := range t.cols {
+			used[i] = true
+		}
+	}
+	var res []string
+	for i, h := range m.header {
+		if !used[i] {
+			res = append(res, h)
+		}
+	}
+	return res
+}
+
+// computedIDs returns the Property IDs of the computed values, sorted.
+func (m *columnMap) computedIDs() []string {
+	res := make([]string, 0, len(m.computed))
+	for propID := range m.computed {
+		res = append(res, propID)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// keep returns true if the row passes every filter.
+func (m *columnMap) keep(rec []string) bool {
+	for _, rf := range m.filters {
+		if !rf.keep(rec) {
+			return false
+		}
+	}
+	return true
+}
+
+// types returns the Type IDs of the entity in a row: the Type ID of the
+// file followed by the values of the type column, without duplicates.
+// Values are also split on commas, since commas separate Type IDs.
+func (m *columnMap) types(rec []string) []string {
+	var res []string
+	if m.typeID != "" {
+		res = append(res, m.typeID)
+	}
+	if m.typeCol < 0 {
+		return res
+	}
+	for _, v := range m.values(rec, m.typeCol) {
+		for _, t := range strings.Split(v, ",") {
+			t = strings.TrimSpace(t)
+			if isBlank(t) {
+				continue
+			}
+			dup := false
+			for _, x := range res {
+				dup = dup || x == t
+			}
+			if !dup {
+				res = append(res, t)
+			}
+		}
+	}
+	return res
+}
+
+// values returns the non-blank values of a column in a row, split by the
+// column's delimiter and run through its transforms.
+func (m *columnMap) values(rec []string, i int) []string {
+	vals := strings.Split(rec[i], multiValueSep)
+	if delim, ok := m.delims[i]; ok && delim != "" {
+		var split []string
+		for _, v := range vals {
+			split = append(split, splitValues(v, delim)...)
+		}
+		vals = split
+	}
+	return m.transforms[i].apply(vals)
+}
+
+// entity returns the ID, name and properties of the entity in a row, or
+// false if the row has a blank ID or name and can't be loaded.
+func (m *columnMap) entity(rec []string) (id, name string, props map[string]interface{}, ok bool) {
+	props = make(map[string]interface{})
+	for i, propID := range m.props {
+		if propID == "" {
+			continue
+		}
+		vals := m.values(rec, i)
+		if len(vals) == 0 {
+			continue
+		}
+		switch propID {
+		case "id":
+			id = vals[0]
+		case "name":
+			name = vals[0]
+		case "description":
+			props[propID] = vals[0]
+		default:
+			if len(vals) == 1 {
+				props[propID] = vals[0]
+			} else {
+				props[propID] = vals
+			}
+		}
+	}
+
+	// computed values replace any mapped column of the same property, and
+	// an ID or name missing one of its columns drops the row
+	for propID, t := range m.computed {
+		v, vok := t.expand(rec)
+		switch propID {
+		case "id", "name":
+			if !vok {
+				return "", "", nil, false
+			}
+			if propID == "id" {
+				id = v
+			} else {
+				name = v
+			}
+		default:
+			if !vok || isBlank(v) {
+				delete(props, propID)
+			} else {
+				props[propID] = v
+			}
+		}
+	}
+	return id, name, props, id != "" && name != ""
+}
+
+// template is a computed value, made of literal text and references to
+// columns written as {column}, e.g. "{#tax_id}:{GeneID}". A template
+// without references is a constant value. References use the values of
+// the columns as they are in the file, without transforms.
+type template struct {
+	text string
+
+	// lits has one more element than cols, and they are interleaved.
+	lits []string
+	cols []int
+}
+
+var templateRefs = regexp.MustCompile(`\{([^{}]+)\}`)
+
+// parseTemplate parses a computed value, resolving its column references
+// against the header of the file.
+func parseTemplate(header []string, s string) (*template, error) {
+	t := &template{text: s}
+	last := 0
+	for _, loc := range templateRefs.FindAllStringSubmatchIndex(s, -1) {
+		i, err := findColumn(header, s[loc[2]:loc[3]])
+		if err != nil {
+			return nil, err
+		}
+		t.lits = append(t.lits, s[last:loc[0]])
+		t.cols = append(t.cols, i)
+		last = loc[1]
+	}
+	t.lits = append(t.lits, s[last:])
+	return t, nil
+}
+
+// expand returns the computed value of a row, or false if any referenced
+// column is blank. Columns with several values use the first.
+func (t *template) expand(rec []string) (string, bool) {
+	res := t.lits[0]
+	for j, i := range t.cols {
+		v := rec[i]
+		if k := strings.Index(v, multiValueSep); k >= 0 {
+			v = v[:k]
+		}
+		if isBlank(v) {
+			return "", false
+		}
+		res += v + t.lits[j+1]
+	}
+	return res, true
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var geneHeader = []string{"#tax_id", "GeneID", "Symbol", "Synonyms", "dbXrefs", "2"}
+
+func TestFindColumn(t *testing.T) {
+	tests := []struct {
+		col  string
+		want int
+		err  bool
+	}{
+		{"GeneID", 1, false},
+		{"#tax_id", 0, false},
+		{"0", 0, false},
+		{"4", 4, false},
+		// a header name wins over an index
+		{"2", 5, false},
+		{"geneid", -1, true},
+		{"6", -1, true},
+		{"-1", -1, true},
+		{"", -1, true},
+	}
+	for _, tc := range tests {
+		got, err := findColumn(geneHeader, tc.col)
+		if got != tc.want || (err != nil) != tc.err {
+			t.Errorf("findColumn(%q) = %d, %v, want %d (error %v)", tc.col, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	row := []string{"9606", "672", "BRCA1", "BRCAI" + multiValueSep + "IRIS", "-", ""}
+	tests := []struct {
+		tmpl string
+		want string
+		ok   bool
+	}{
+		{"{#tax_id}:{GeneID}", "9606:672", true},
+		{"gene {Symbol} ({1})", "gene BRCA1 (672)", true},
+		{"human", "human", true},
+		{"", "", true},
+		// multi-valued columns use their first value
+		{"{Synonyms}", "BRCAI", true},
+		// blank columns fail the whole value
+		{"{Symbol}/{dbXrefs}", "", false},
+		{"{Symbol}/{2}", "", false},
+		{"{2}:{GeneID}", "", false},
+		// braces without a column name are literal
+		{"{}{Symbol}", "{}BRCA1", true},
+	}
+	for _, tc := range tests {
+		tmpl, err := parseTemplate(geneHeader, tc.tmpl)
+		if err != nil {
+			t.Errorf("parseTemplate(%q): %v", tc.tmpl, err)
+			continue
+		}
+		if got, ok := tmpl.expand(row); got != tc.want || ok != tc.ok {
+			t.Errorf("expand(%q) = %q, %v, want %q, %v", tc.tmpl, got, ok, tc.want, tc.ok)
+		}
+	}
+
+	if _, err := parseTemplate(geneHeader, "{Name}"); err == nil {
+		t.Error("parseTemplate({Name}) did not fail for a missing column")
+	}
+}
+
+func TestColumnMapEntity(t *testing.T) {
+	fc := FileConfig{
+		ID: "gene",
+		Properties: map[string]string{
+			"GeneID":   "id",
+			"Symbol":   "name",
+			"#tax_id":  "tax_id",
+			"Synonyms": "synonyms",
+			"dbXrefs":  "dbxrefs",
+			"2":        "",
+		},
+		Delimiters: map[string]string{"Synonyms": "|"},
+		Computed:   map[string]string{"curie": "NCBIGene:{GeneID}", "tax_id": "taxon:{#tax_id}"},
+	}
+	cols, err := newColumnMap(fc, geneHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, name, props, ok := cols.entity([]string{"9606", "672", "BRCA1", "BRCAI|BRCC1| - |IRIS", "-", "x"})
+	want := map[string]interface{}{
+		"tax_id":   "taxon:9606",
+		"synonyms": []string{"BRCAI", "BRCC1", "IRIS"},
+		"curie":    "NCBIGene:672",
+	}
+	if !ok || id != "672" || name != "BRCA1" || !reflect.DeepEqual(props, want) {
+		t.Errorf("entity = %q, %q, %v, %v, want 672, BRCA1, %v", id, name, props, ok, want)
+	}
+
+	// a blank computed value removes the property
+	_, _, props, ok = cols.entity([]string{"-", "675", "BRCA2", "FANCD1", "-", ""})
+	want = map[string]interface{}{"synonyms": "FANCD1", "curie": "NCBIGene:675"}
+	if !ok || !reflect.DeepEqual(props, want) {
+		t.Errorf("entity properties = %v, %v, want %v", props, ok, want)
+	}
+
+	// the column mapped to "" is not used
+	if got := cols.unmatched(); !reflect.DeepEqual(got, []string{"2"}) {
+		t.Errorf("unmatched = %v, want [2]", got)
+	}
+	if got := cols.computedIDs(); !reflect.DeepEqual(got, []string{"curie", "tax_id"}) {
+		t.Errorf("computedIDs = %v, want [curie tax_id]", got)
+	}
+}
+
+func TestNewColumnMapErrors(t *testing.T) {
+	tests := []struct {
+		fc   FileConfig
+		want string
+	}{
+		{FileConfig{Properties: map[string]string{"Name": "name"}}, "column 'Name' not found"},
+		{FileConfig{Delimiters: map[string]string{"9": "|"}}, "column '9' not found"},
+		{FileConfig{TypeColumn: "type"}, "column 'type' not found"},
+		{FileConfig{Computed: map[string]string{"curie": "{Gene}"}}, "computed 'curie': column 'Gene'"},
+		{FileConfig{Transforms: map[string][]Transform{"Symbol": {{Op: "reverse"}}}},
+			"column 'Symbol': transform reverse: unknown transform"},
+	}
+	for _, tc := range tests {
+		_, err := newColumnMap(tc.fc, geneHeader)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("newColumnMap(%+v) error = %v, want %q", tc.fc, err, tc.want)
+		}
+	}
+}
+
+func TestColumnMapEntityBlank(t *testing.T) {
+	fc := FileConfig{
+		Properties: map[string]string{"GeneID": "id", "Symbol": "name"},
+		Transforms: map[string][]Transform{"Symbol": {{Op: "null", Arg: "NEWENTRY"}}},
+	}
+	cols, err := newColumnMap(fc, geneHeader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		rec  []string
+		ok   bool
+	}{
+		{"complete", []string{"9606", "672", "BRCA1", "", "", ""}, true},
+		// rows without an ID or a name can't be loaded
+		{"dash id", []string{"9606", "-", "BRCA1", "", "", ""}, false},
+		{"empty id", []string{"9606", "", "BRCA1", "", "", ""}, false},
+		{"empty name", []string{"9606", "672", "", "", "", ""}, false},
+		{"null name", []string{"9606", "672", "NEWENTRY", "", "", ""}, false},
+		{"dash name", []string{"9606", "672", "-", "", "", ""}, false},
+	}
+	for _, tc := range tests {
+		id, name, _, ok := cols.entity(tc.rec)
+		if ok != tc.ok {
+			t.Errorf("%s: entity = %q, %q, %v, want %v", tc.name, id, name, ok, tc.ok)
+		}
+	}
+
+	// computed IDs and names missing a column drop the row, even if a
+	// mapped column has a value
+	fc.Computed = map[string]string{"id": "{#tax_id}:{GeneID}", "name": "{Symbol} ({Synonyms})"}
+	if cols, err = newColumnMap(fc, geneHeader); err != nil {
+		t.Fatal(err)
+	}
+	tests = []struct {
+		name string
+		rec  []string
+		ok   bool
+	}{
+		{"complete", []string{"9606", "672", "BRCA1", "IRIS", "", ""}, true},
+		{"blank id column", []string{"-", "672", "BRCA1", "IRIS", "", ""}, false},
+		{"blank name column", []string{"9606", "672", "BRCA1", "", "", ""}, false},
+	}
+	for _, tc := range tests {
+		id, name, _, ok := cols.entity(tc.rec)
+		if ok != tc.ok {
+			t.Errorf("%s: entity = %q, %q, %v, want %v", tc.name, id, name, ok, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter describes a condition on a column that rows must meet to be kept,
+// e.g. {"column":"#tax_id","in":["9606","10090"]}. If several conditions
+// are given they must all be met. Filters use the values of the columns as
+// they are in the file, without transforms, and columns with several
+// values are kept if any value meets the conditions.
+type Filter struct {
+	// Column given by header name, or by 0-based index.
+	Column string `json:"column"`
+
+	// Equals keeps rows where the column has this value.
+	Equals string `json:"equals,omitempty"`
+
+	// In keeps rows where the column has one of these values.
+	In []string `json:"in,omitempty"`
+
+	// Regex keeps rows where the column matches this regular expression.
+	Regex string `json:"regex,omitempty"`
+
+	// NotEmpty keeps rows where the column is not blank ("" or "-").
+	NotEmpty bool `json:"not_empty,omitempty"`
+}
+
+func (f Filter) String() string {
+	var conds []string
+	if f.Equals != "" {
+		conds = append(conds, fmt.Sprintf("= '%s'", f.Equals))
+	}
+	if len(f.In) > 0 {
+		conds = append(conds, fmt.Sprintf("in ('%s')", strings.Join(f.In, "', '")))
+	}
+	if f.Regex != "" {
+		conds = append(conds, fmt.Sprintf("~ /%s/", f.Regex))
+	}
+	if f.NotEmpty {
+		conds = append(conds, "not empty")
+	}
+	return fmt.Sprintf("'%s' %s", f.Column, strings.Join(conds, " and "))
+}
+
+// rowFilter is a Filter resolved against the header of a file.
+type rowFilter struct {
+	Filter
+	col int
+	in  map[string]struct{}
+	re  *regexp.Regexp
+}
+
+func newRowFilter(f Filter, header []string) (*rowFilter, error) {
+	if f.Equals == "" && len(f.In) == 0 && f.Regex == "" && !f.NotEmpty {
+		return nil, fmt.Errorf("filter on '%s' has no conditions", f.Column)
+	}
+	col, err := findColumn(header, f.Column)
+	if err != nil {
+		return nil, err
+	}
+	rf := &rowFilter{Filter: f, col: col}
+	if len(f.In) > 0 {
+		rf.in = make(map[string]struct{}, len(f.In))
+		for _, v := range f.In {
+			rf.in[v] = struct{}{}
+		}
+	}
+	if f.Regex != "" {
+		rf.re, err = regexp.Compile(f.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("filter on '%s': %v", f.Column, err)
+		}
+	}
+	return rf, nil
+}
+
+// keep returns true if the row meets every condition of the filter.
+func (rf *rowFilter) keep(rec []string) bool {
+	for _, v := range strings.Split(rec[rf.col], multiValueSep) {
+		if rf.match(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// match returns true if a value meets every condition of the filter.
+func (rf *rowFilter) match(v string) bool {
+	if rf.Equals != "" && v != rf.Equals {
+		return false
+	}
+	if rf.in != nil {
+		if _, ok := rf.in[v]; !ok {
+			return false
+		}
+	}
+	if rf.re != nil && !rf.re.MatchString(v) {
+		return false
+	}
+	if rf.NotEmpty && isBlank(v) {
+		return false
+	}
+	return true
+}
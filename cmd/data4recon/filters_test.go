@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+var filterHeader = []string{"#tax_id", "GeneID", "Symbol", "type_of_gene"}
+
+var filterRows = [][]string{
+	{"9606", "672", "BRCA1", "protein-coding"},
+	{"9606", "100", "MIR100", "ncRNA"},
+	{"10090", "12189", "Brca1", "protein-coding"},
+	{"10116", "497672", "Brca1", "protein-coding"},
+	{"9606", "7157", "-", "protein-coding"},
+	{"559292", "850000", "", "ncRNA" + multiValueSep + "protein-coding"},
+}
+
+func TestFilterKeep(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		kept    int
+		dropped int
+	}{
+		{"none", nil, 6, 0},
+		{"equals", []Filter{{Column: "#tax_id", Equals: "9606"}}, 3, 3},
+		{"in", []Filter{{Column: "0", In: []string{"9606", "10090"}}}, 4, 2},
+		{"regex", []Filter{{Column: "Symbol", Regex: "(?i)^brca"}}, 3, 3},
+		{"not empty", []Filter{{Column: "Symbol", NotEmpty: true}}, 4, 2},
+		// any of several values can meet the conditions
+		{"multi-valued", []Filter{{Column: "type_of_gene", Equals: "protein-coding"}}, 5, 1},
+		// every condition of a filter, and every filter, must be met
+		{"conditions", []Filter{{Column: "#tax_id", In: []string{"9606", "10090"}, Regex: "^9"}}, 3, 3},
+		{"filters", []Filter{
+			{Column: "#tax_id", Equals: "9606"},
+			{Column: "type_of_gene", Equals: "protein-coding"},
+			{Column: "Symbol", NotEmpty: true},
+		}, 1, 5},
+	}
+	for _, tc := range tests {
+		cols, err := newColumnMap(FileConfig{Filters: tc.filters}, filterHeader)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		kept, dropped := 0, 0
+		for _, rec := range filterRows {
+			if cols.keep(rec) {
+				kept++
+			} else {
+				dropped++
+			}
+		}
+		if kept != tc.kept || dropped != tc.dropped {
+			t.Errorf("%s: %d kept, %d dropped, want %d kept, %d dropped",
+				tc.name, kept, dropped, tc.kept, tc.dropped)
+		}
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		f    Filter
+		want string
+	}{
+		{Filter{Column: "Symbol"}, "filter on 'Symbol' has no conditions"},
+		{Filter{Column: "Name", Equals: "BRCA1"}, "column 'Name' not found"},
+		{Filter{Column: "Symbol", Regex: "(brca"}, "filter on 'Symbol': error parsing regexp"},
+	}
+	for _, tc := range tests {
+		_, err := newRowFilter(tc.f, filterHeader)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("newRowFilter(%s) error = %v, want %q", tc.f, err, tc.want)
+		}
+	}
+}
+
+func TestFilterString(t *testing.T) {
+	f := Filter{Column: "#tax_id", In: []string{"9606", "10090"}, Regex: "^9", NotEmpty: true}
+	want := "'#tax_id' in ('9606', '10090') and ~ /^9/ and not empty"
+	if got := f.String(); got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+}
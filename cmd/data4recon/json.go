@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// jsonReader reads a JSON Lines file, or a JSON array of objects, as rows
+// of the values found at a list of JSON paths. The header row is the list
+// of paths, and multiple values for a path are joined by multiValueSep.
+type jsonReader struct {
+	header []string
+	paths  [][]string
+
+	dec    *json.Decoder
+	array  bool
+	inited bool
+
+	// empty is true for a file with no JSON values, which has no rows.
+	empty bool
+}
+
+func newJSONReader(r io.Reader, paths []string) (*jsonReader, error) {
+	br := bufio.NewReader(r)
+
+	// a JSON array is read one element at a time
+	array, empty := false, false
+	for {
+		c, err := br.ReadByte()
+		if err == io.EOF {
+			empty = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		array = c == '['
+		br.UnreadByte()
+		break
+	}
+
+	jr := &jsonReader{
+		header: paths,
+		dec:    json.NewDecoder(br),
+		array:  array,
+		empty:  empty,
+	}
+	jr.dec.UseNumber()
+	if array {
+		// read the opening bracket, so that the decoder skips the
+		// commas between the elements
+		if _, err := jr.dec.Token(); err != nil {
+			return nil, err
+		}
+	}
+	for _, p := range paths {
+		jr.paths = append(jr.paths, splitJSONPath(p))
+	}
+	return jr, nil
+}
+
+// splitJSONPath splits a path such as "$.genes.geneName.value" into keys.
+func splitJSONPath(p string) []string {
+	p = strings.TrimPrefix(strings.TrimPrefix(p, "$"), ".")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, ".")
+}
+
+// Read returns the header on the first call, and then the values of each
+// object in the file.
+func (jr *jsonReader) Read() ([]string, error) {
+	if !jr.inited {
+		jr.inited = true
+		return jr.header, nil
+	}
+	if jr.empty || (jr.array && !jr.dec.More()) {
+		return nil, io.EOF
+	}
+	var x interface{}
+	if err := jr.dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	if _, ok := x.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("expected a JSON object, got: %T", x)
+	}
+	rec := make([]string, len(jr.paths))
+	for i, p := range jr.paths {
+		rec[i] = strings.Join(jsonValues(x, p, nil), multiValueSep)
+	}
+	return rec, nil
+}
+
+// jsonValues appends the values found at a path to res. Arrays are
+// searched for every value, unless the next key in the path is an index.
+func jsonValues(x interface{}, path []string, res []string) []string {
+	switch v := x.(type) {
+	case nil:
+		return res
+
+	case []interface{}:
+		if len(path) > 0 {
+			if i, err := strconv.Atoi(path[0]); err == nil {
+				if i >= 0 && i < len(v) {
+					return jsonValues(v[i], path[1:], res)
+				}
+				return res
+			}
+		}
+		for _, e := range v {
+			res = jsonValues(e, path, res)
+		}
+		return res
+
+	case map[string]interface{}:
+		if len(path) == 0 {
+			// objects are kept as JSON
+			raw, _ := json.Marshal(v)
+			return append(res, string(raw))
+		}
+		return jsonValues(v[path[0]], path[1:], res)
+	}
+
+	if len(path) > 0 {
+		return res
+	}
+	return append(res, fmt.Sprint(x))
+}
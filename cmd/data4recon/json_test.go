@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const uniprotEntry = `{
+	"primaryAccession": "P38398",
+	"organism": {"taxonId": 9606, "scientificName": "Homo sapiens"},
+	"genes": [
+		{"geneName": {"value": "BRCA1"}, "synonyms": [{"value": "RNF53"}]},
+		{"geneName": {"value": "BRCC1"}, "synonyms": [{"value": "PPP1R53"}, {"value": "IRIS"}]}
+	],
+	"reviewed": true,
+	"mass": 207721.5,
+	"comments": [],
+	"keywords": [{"id": "KW-0002", "name": "3D-structure"}],
+	"features": null
+}`
+
+func TestSplitJSONPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"$.genes.geneName.value", []string{"genes", "geneName", "value"}},
+		{"genes.geneName.value", []string{"genes", "geneName", "value"}},
+		{"$.primaryAccession", []string{"primaryAccession"}},
+		{"$", nil},
+		{"", nil},
+	}
+	for _, tc := range tests {
+		if got := splitJSONPath(tc.path); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("splitJSONPath(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestJSONValues(t *testing.T) {
+	dec := json.NewDecoder(strings.NewReader(uniprotEntry))
+	dec.UseNumber()
+	var x interface{}
+	if err := dec.Decode(&x); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"$.primaryAccession", []string{"P38398"}},
+		{"$.organism.taxonId", []string{"9606"}},
+		{"$.mass", []string{"207721.5"}},
+		{"$.reviewed", []string{"true"}},
+		// arrays are searched for every value, at any depth
+		{"$.genes.geneName.value", []string{"BRCA1", "BRCC1"}},
+		{"$.genes.synonyms.value", []string{"RNF53", "PPP1R53", "IRIS"}},
+		// unless the next key is an index
+		{"$.genes.0.geneName.value", []string{"BRCA1"}},
+		{"$.genes.1.synonyms.1.value", []string{"IRIS"}},
+		{"$.genes.2.geneName.value", nil},
+		// objects are kept as JSON
+		{"$.keywords", []string{`{"id":"KW-0002","name":"3D-structure"}`}},
+		{"$.organism", []string{`{"scientificName":"Homo sapiens","taxonId":9606}`}},
+		// missing, null and empty values have no values
+		{"$.comments", nil},
+		{"$.features", nil},
+		{"$.sequence.length", nil},
+		{"$.primaryAccession.value", nil},
+	}
+	for _, tc := range tests {
+		if got := jsonValues(x, splitJSONPath(tc.path), nil); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("jsonValues(%q) = %q, want %q", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestJSONReader(t *testing.T) {
+	paths := []string{"$.id", "$.names.value"}
+	tests := []struct {
+		name string
+		in   string
+		want [][]string
+	}{
+		{"lines", `{"id": "a", "names": [{"value": "x"}, {"value": "y"}]}
+			{"id": "b"}
+`, [][]string{paths, {"a", "x" + multiValueSep + "y"}, {"b", ""}}},
+		{"array", ` [{"id": "a", "names": {"value": "x"}}, {"id": 2}]`,
+			[][]string{paths, {"a", "x"}, {"2", ""}}},
+		{"empty array", `[]`, [][]string{paths}},
+		// empty files have no rows
+		{"empty", "", [][]string{paths}},
+		{"blank", " \n\t\r\n", [][]string{paths}},
+	}
+	for _, tc := range tests {
+		jr, err := newJSONReader(strings.NewReader(tc.in), paths)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		var got [][]string
+		rec, err := jr.Read()
+		for err == nil {
+			got = append(got, rec)
+			rec, err = jr.Read()
+		}
+		if err != io.EOF {
+			t.Errorf("%s: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: rows = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+
+	jr, err := newJSONReader(strings.NewReader(`{"id": "a"} ["b"]`), paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr.Read()
+	jr.Read()
+	if _, err = jr.Read(); err == nil || !strings.Contains(err.Error(), "expected a JSON object") {
+		t.Errorf("Read of an array error = %v, want expected a JSON object", err)
+	}
+}
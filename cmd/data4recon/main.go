@@ -15,6 +15,8 @@ import (
 	"regexp"
 	"strings"
 
+	"github.com/joiningdata/recongo/model"
+
 	// sqlite database drivers
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -24,14 +26,19 @@ type inputConfig struct {
 	IdentifierNamespace string            `json:"identifier_namespace"`
 	SchemaNamespace     string            `json:"schema_namespace"`
 	Properties          map[string]string `json:"property_names"`
+	PropertyTypes       map[string]string `json:"property_types,omitempty"`
 	ViewURL             string            `json:"view_url"`
+	Matcher             string            `json:"matcher,omitempty"`
 
 	Files []FileConfig `json:"files"`
+
+	// History lists files of retired IDs and their replacements (optional).
+	History []HistoryConfig `json:"history,omitempty"`
 }
 
 // FileConfig describes the configuration for a data file.
 type FileConfig struct {
-	// ID of the Type of data in this file.
+	// ID of the Type of data in this file (required unless TypeColumn is set).
 	ID string `json:"id"`
 
 	// Name of the Type of data in this file.
@@ -40,11 +47,89 @@ type FileConfig struct {
 	// Description of the data type for this file.
 	Description string `json:"description"`
 
+	// Filename that contains the data (CSV, tab-delimited, JSON Lines or a
+	// JSON array of objects, optionally gzipped)
+	Filename string `json:"filename"`
+
+	// Format of the file: "csv", "tsv" or "json" (for JSON Lines and JSON
+	// arrays). By default it is guessed from the filename, and files not
+	// ending in .csv, .json, .jsonl or .ndjson are tab-delimited.
+	Format string `json:"format,omitempty"`
+
+	// TypeColumn is a column (by header name or 0-based index) whose values
+	// are added to the Types of each row, after the Type ID above (if any).
+	// Values are split on commas or the column's delimiter for multiple
+	// Types per row, and transforms can clean them up. Rows without any
+	// Type are dropped.
+	TypeColumn string `json:"type_column,omitempty"`
+
+	// TypeNames maps the Type IDs found in the TypeColumn to display names.
+	// Types without a name are named after their ID.
+	TypeNames map[string]string `json:"type_names,omitempty"`
+
+	// Properties maps each column of the file to a Property ID or blank.
+	// Columns are given by header name, or by 0-based index if no column
+	// has that name. In JSON files columns are JSON paths of the values in
+	// each object, e.g. "genes.geneName.value", where arrays are searched
+	// for every value (making multi-valued properties) or indexed by number.
+	// Rows with a blank "id" or "name" are dropped.
+	Properties map[string]string `json:"column2property"`
+
+	// Delimiters maps columns of the file to a delimiter used to split the
+	// column into a list of values (for multi-valued properties).
+	Delimiters map[string]string `json:"column_delimiters,omitempty"`
+
+	// Transforms maps columns of the file to a list of transforms applied in
+	// order to each value, after splitting by the column's delimiter.
+	Transforms map[string][]Transform `json:"column_transforms,omitempty"`
+
+	// Filters are conditions on columns that rows must meet to be loaded.
+	Filters []Filter `json:"filters,omitempty"`
+
+	// Computed maps Property IDs (including "id" and "name") to a value
+	// built from other columns, e.g. "{#tax_id}:{GeneID}". Columns are
+	// referenced as {column}, and a value without references is a constant.
+	// A value with a blank column is left out, or drops the row for "id"
+	// and "name".
+	Computed map[string]string `json:"computed,omitempty"`
+}
+
+// HistoryConfig describes a file of retired Entity IDs and the IDs
+// that replaced them, e.g. NCBI's gene_history.
+type HistoryConfig struct {
+	// Type ID of the entities (defaults to the first file's Type ID, and is
+	// required if that is blank).
+	Type string `json:"type,omitempty"`
+
 	// Filename that contains the data (CSV or tab-delimited)
 	Filename string `json:"filename"`
 
-	// Properties maps each 0-based column of the file to a Property ID or blank.
-	Properties map[int]string `json:"column2property"`
+	// OldIDColumn is the 0-based column of the retired IDs.
+	OldIDColumn int `json:"old_id_column"`
+
+	// NewIDColumn is the 0-based column of the IDs that replaced them.
+	// Rows with a blank new ID (discontinued without a replacement) are skipped.
+	NewIDColumn int `json:"new_id_column"`
+}
+
+// type ID prefix of rows mapping a retired ID to its replacement.
+const replacedByType = "replaced_by"
+
+// isBlank returns true for values that mean there is no data.
+func isBlank(v string) bool {
+	return v == "" || v == "-"
+}
+
+// splitValues splits a multi-valued column into its non-blank values.
+func splitValues(v, delim string) []string {
+	var res []string
+	for _, x := range strings.Split(v, delim) {
+		x = strings.TrimSpace(x)
+		if !isBlank(x) {
+			res = append(res, x)
+		}
+	}
+	return res
 }
 
 func showHelp() {
@@ -54,8 +139,24 @@ func showHelp() {
 	cfgset.Properties = map[string]string{
 		"another_property": "another property defined on the item",
 	}
+	cfgset.PropertyTypes = map[string]string{
+		"tax_id": "int",
+	}
 	cfgset.Files = make([]FileConfig, 2)
-	cfgset.Files[0].Properties = map[int]string{1: "id", 2: "name", 0: "tax_id", 9: "description", 5: "another_property"}
+	cfgset.Files[0].Properties = map[string]string{"GeneID": "id", "Symbol": "name", "#tax_id": "tax_id",
+		"description": "description", "Synonyms": "aliases", "5": "another_property"}
+	cfgset.Files[0].Delimiters = map[string]string{"Synonyms": "|", "5": "|"}
+	cfgset.Files[0].TypeColumn = "type_of_gene"
+	cfgset.Files[0].TypeNames = map[string]string{"ncRNA": "Non-coding RNA"}
+	cfgset.Files[0].Transforms = map[string][]Transform{
+		"Synonyms": {{Op: "trim"}, {Op: "null", Arg: "NEWENTRY"}},
+		"5":        {{Op: "strip_prefix", Arg: "HGNC:"}},
+	}
+	cfgset.Files[0].Filters = []Filter{
+		{Column: "#tax_id", In: []string{"9606", "10090"}},
+		{Column: "Symbol", NotEmpty: true},
+	}
+	cfgset.Files[0].Computed = map[string]string{"curie": "NCBIGene:{GeneID}", "source": "NCBI"}
 
 	raw, _ := json.MarshalIndent(cfgset, "", "  ")
 	fmt.Println(string(raw))
@@ -72,40 +173,110 @@ func loadConfig(fn string) (*inputConfig, error) {
 	}
 	err = json.NewDecoder(f).Decode(&cfgset)
 	if err != nil {
+		f.Close()
 		return nil, err
 	}
+	for _, fc := range cfgset.Files {
+		// rows without any type would all be dropped
+		if fc.ID == "" && fc.TypeColumn == "" {
+			f.Close()
+			return nil, fmt.Errorf("%s: file needs a type ID (\"id\") or a \"type_column\"", fc.Filename)
+		}
+	}
+	for i, hc := range cfgset.History {
+		if hc.Type == "" && len(cfgset.Files) > 0 {
+			cfgset.History[i].Type = cfgset.Files[0].ID
+		}
+		if cfgset.History[i].Type == "" {
+			f.Close()
+			return nil, fmt.Errorf("%s: history needs a type, since the first file has no type ID", hc.Filename)
+		}
+	}
 
 	return cfgset, f.Close()
 }
 
-func getReader(fn string) (*csv.Reader, error) {
+// openFile opens a file, decompressing it if the name ends with ".gz".
+func openFile(fn string) (io.Reader, error) {
 	fx, err := os.Open(fn)
 	if err != nil {
 		return nil, err
 	}
-	var fr io.ReadCloser = fx
 	if strings.HasSuffix(strings.ToLower(fn), ".gz") {
-		fr, err = gzip.NewReader(fx)
+		fr, err := gzip.NewReader(fx)
 		if err != nil {
 			fx.Close()
 			return nil, err
 		}
-		fn = fn[:len(fn)-3] // remove the ".gz"
+		return fr, nil
+	}
+	return fx, nil
+}
+
+// fileFormat returns the format of a file from its name: "json" for JSON
+// and JSON Lines, "csv", or "tsv" for anything else.
+func fileFormat(fn string) string {
+	fn = strings.TrimSuffix(strings.ToLower(fn), ".gz")
+	switch {
+	case strings.HasSuffix(fn, ".json"), strings.HasSuffix(fn, ".jsonl"),
+		strings.HasSuffix(fn, ".ndjson"):
+		return "json"
+	case strings.HasSuffix(fn, "csv"):
+		return "csv"
+	}
+	// if it doesn't end with csv assume it's tab-delimited
+	return "tsv"
+}
+
+// getReader returns a reader for a delimited file in the format given:
+// "csv" for comma-separated, or "tsv" for tab-separated with lazy quotes.
+func getReader(fn, format string) (*csv.Reader, error) {
+	fr, err := openFile(fn)
+	if err != nil {
+		return nil, err
 	}
 
 	r := csv.NewReader(fr)
-	if !strings.HasSuffix(strings.ToLower(fn), "csv") {
-		// if it doesn't end with csv assume it's tab-delimited
+	if format != "csv" {
 		r.Comma = '\t'
 		r.LazyQuotes = true
 	}
 	return r, nil
 }
 
+// recordReader reads the header of a data file, and then each row.
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+// getFileReader returns a reader for a data file. The columns of JSON
+// files are the JSON paths used in the file's configuration.
+func getFileReader(fc FileConfig) (recordReader, error) {
+	format := fc.Format
+	if format == "" {
+		format = fileFormat(fc.Filename)
+	}
+	switch format {
+	case "json":
+		fr, err := openFile(fc.Filename)
+		if err != nil {
+			return nil, err
+		}
+		return newJSONReader(fr, configColumns(fc))
+	case "csv", "tsv":
+		r, err := getReader(fc.Filename, format)
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("%s: unknown format '%s'", fc.Filename, format)
+}
+
 var seps = regexp.MustCompile("[_. -]+")
 
 func main() {
-	outname := flag.String("o", "-", "output to `filename(.txt|.sqlite)`")
+	outname := flag.String("o", "-", "output to `filename(.txt|.sqlite|.sql)`")
 	dryRun := flag.Bool("p", false, "`pretend` to do the parsing (aka dry run)")
 	flag.Parse()
 
@@ -131,29 +302,36 @@ func main() {
 	var out [4]string
 
 	haveTypes := make(map[string]struct{})
-	typeSet := make([]map[string]string, len(cfgset.Files))
-	for i, fc := range cfgset.Files {
-		if _, ok := haveTypes[fc.ID]; !ok {
-			haveTypes[fc.ID] = struct{}{}
-			typeSet[i] = map[string]string{
-				"id":          fc.ID,
-				"name":        fc.Name,
-				"description": fc.Description,
+	var typeSet []map[string]string
+	addType := func(id, name, description string) {
+		if _, ok := haveTypes[id]; !ok {
+			haveTypes[id] = struct{}{}
+			typeSet = append(typeSet, map[string]string{
+				"id":          id,
+				"name":        name,
+				"description": description,
 				"url":         cfgset.ViewURL,
-			}
+			})
+		}
+	}
+	for _, fc := range cfgset.Files {
+		if fc.ID != "" {
+			addType(fc.ID, fc.Name, fc.Description)
 		}
 
 		log.Printf("Reading data from: '%s'...", fc.Filename)
-		r, err := getReader(fc.Filename)
+		r, err := getFileReader(fc)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		out[2] = fc.ID
-
 		header, err := r.Read()
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("%s: cannot read the header: %v", fc.Filename, err)
+		}
+		cols, err := newColumnMap(fc, header)
+		if err != nil {
+			log.Fatalf("%s: %v", fc.Filename, err)
 		}
 		maxh := 0
 		for _, h := range header {
@@ -162,35 +340,66 @@ func main() {
 			}
 		}
 		for i, h := range header {
-			log.Printf("Column %3d. %*s ==> '%s'", i, -maxh, h, fc.Properties[i])
+			if p, ok := cols.transforms[i]; ok {
+				log.Printf("Column %3d. %*s ==> '%s' [%s]", i, -maxh, h, cols.props[i], p)
+			} else {
+				log.Printf("Column %3d. %*s ==> '%s'", i, -maxh, h, cols.props[i])
+			}
+		}
+		for _, propID := range cols.computedIDs() {
+			log.Printf("Computed    %*s ==> '%s'", -maxh, cols.computed[propID].text, propID)
+		}
+		if unmatched := cols.unmatched(); len(unmatched) > 0 {
+			log.Printf("Unmatched columns: '%s'", strings.Join(unmatched, "', '"))
+		}
+		for _, rf := range cols.filters {
+			log.Printf("Filter: %s", rf)
+		}
+		if cols.typeCol >= 0 {
+			log.Printf("Types from column %d. '%s'", cols.typeCol, header[cols.typeCol])
 		}
 		if *dryRun {
 			continue
 		}
 
-		nrec := 0
+		nrec, ndrop := 0, 0
 		fmt.Fprint(os.Stderr, "Reading data...\n")
 		rec, err := r.Read()
 		for err == nil {
 			nrec++
-			fmt.Fprintf(os.Stderr, "  %10d\r", nrec)
+			var types []string
+			var props map[string]interface{}
+			keep := cols.keep(rec)
+			if keep {
+				types = cols.types(rec)
+				keep = len(types) > 0
+			}
+			if keep {
+				// rows without an ID or name would fail to load
+				out[0], out[1], props, keep = cols.entity(rec)
+			}
+			if !keep {
+				ndrop++
+			}
+			fmt.Fprintf(os.Stderr, "  %10d kept, %10d dropped\r", nrec-ndrop, ndrop)
 			os.Stderr.Sync()
+			if !keep {
+				rec, err = r.Read()
+				continue
+			}
 
-			props := make(map[string]string)
-			for i, propName := range fc.Properties {
-				switch propName {
-				case "":
-					continue
-				case "id":
-					out[0] = rec[i]
-				case "name":
-					out[1] = rec[i]
-				default:
-					if rec[i] != "" && rec[i] != "-" {
-						props[propName] = rec[i]
+			// register the types found in the type column
+			for _, typeID := range types {
+				if _, ok := haveTypes[typeID]; !ok {
+					name, ok := fc.TypeNames[typeID]
+					if !ok {
+						name = strings.Title(strings.TrimSpace(seps.ReplaceAllString(typeID, " ")))
 					}
+					addType(typeID, name, "")
 				}
 			}
+
+			out[2] = strings.Join(types, ",")
 			if len(props) == 0 {
 				out[3] = "{}"
 			} else {
@@ -203,27 +412,49 @@ func main() {
 				if _, ok := propSet[propName]; !ok {
 					propSet[propName] = make(map[string]struct{})
 				}
-				propSet[propName][out[2]] = struct{}{}
+				for _, typeID := range types {
+					propSet[propName][typeID] = struct{}{}
+				}
 			}
 
 			rec, err = r.Read()
 		}
-		fmt.Fprint(os.Stderr, "\n  Done.\n")
+		fmt.Fprintf(os.Stderr, "  %10d kept, %10d dropped\n  Done.\n", nrec-ndrop, ndrop)
 
 		if err != nil {
 			log.Println(err)
 		}
 	}
 
+	for _, hc := range cfgset.History {
+		log.Printf("Reading ID history from: '%s'...", hc.Filename)
+		err = readHistory(fout, hc, *dryRun)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	fout.Seek(0, io.SeekStart)
 	s := bufio.NewScanner(fout)
 
 	///// everything now being sent to output
 
+	if strings.HasSuffix(*outname, ".sql") {
+		f, err := os.Create(*outname)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = outputToPostgres(f, typeSet, cfgset, propSet, s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if strings.Contains(*outname, "sqlite") {
 		err := outputToSqlite(*outname, typeSet, cfgset, propSet, s)
 		if err != nil {
-			log.Println(err)
+			log.Fatal(err)
 		}
 		return
 	}
@@ -243,6 +474,52 @@ func main() {
 	}
 }
 
+// readHistory writes a row to fout for each retired ID and its replacement.
+func readHistory(fout io.Writer, hc HistoryConfig, dryRun bool) error {
+	r, err := getReader(hc.Filename, fileFormat(hc.Filename))
+	if err != nil {
+		return err
+	}
+	header, err := r.Read()
+	if err != nil {
+		return fmt.Errorf("%s: cannot read the header: %v", hc.Filename, err)
+	}
+	for _, col := range []int{hc.OldIDColumn, hc.NewIDColumn} {
+		if col < 0 || col >= len(header) {
+			return fmt.Errorf("%s: column %d does not exist", hc.Filename, col)
+		}
+	}
+	log.Printf("Retired IDs in column %d ('%s') ==> replaced by column %d ('%s')",
+		hc.OldIDColumn, header[hc.OldIDColumn], hc.NewIDColumn, header[hc.NewIDColumn])
+	if dryRun {
+		return nil
+	}
+
+	var out [4]string
+	out[2] = replacedByType + "," + hc.Type
+	out[3] = "{}"
+	seen := make(map[string]struct{})
+	nrec, nskip := 0, 0
+	rec, err := r.Read()
+	for err == nil {
+		nrec++
+		oldID, newID := rec[hc.OldIDColumn], rec[hc.NewIDColumn]
+		if _, dup := seen[oldID]; dup || isBlank(oldID) || isBlank(newID) || oldID == newID {
+			nskip++
+		} else {
+			seen[oldID] = struct{}{}
+			out[0], out[1] = oldID, newID
+			fmt.Fprintln(fout, strings.Join(out[:], "\t"))
+		}
+		rec, err = r.Read()
+	}
+	log.Printf("  %d retired IDs, %d skipped without a replacement", nrec-nskip, nskip)
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
 func outputToFlatfile(dest io.WriteCloser, typeSet []map[string]string, cfgset *inputConfig,
 	propSet map[string]map[string]struct{}, s *bufio.Scanner) error {
 
@@ -251,11 +528,15 @@ func outputToFlatfile(dest io.WriteCloser, typeSet []map[string]string, cfgset *
 		cfgset.SchemaNamespace, string(typesjson))
 
 	var out [4]string
-	out[3] = "{}"
 
 	for propName, ents := range propSet {
 		out[0] = propName
 		out[1] = strings.Title(strings.TrimSpace(seps.ReplaceAllString(propName, " ")))
+		out[3] = "{}"
+		if vt, ok := cfgset.PropertyTypes[propName]; ok {
+			raw, _ := json.Marshal(map[string]string{"type": vt})
+			out[3] = string(raw)
+		}
 		for etype := range ents {
 			out[2] = "property," + etype
 			fmt.Fprintln(dest, strings.Join(out[:], "\t"))
@@ -275,7 +556,7 @@ func outputToSqlite(filename string, typeSet []map[string]string, cfgset *inputC
 		return err
 	}
 	defer db.Close()
-	for _, ddl := range schema {
+	for _, ddl := range model.SQLiteSchema {
 		_, err = db.Exec(ddl)
 		if err != nil {
 			return err
@@ -303,6 +584,13 @@ func outputToSqlite(filename string, typeSet []map[string]string, cfgset *inputC
 	if err != nil {
 		return err
 	}
+	if cfgset.Matcher != "" {
+		_, err = db.Exec("INSERT INTO recongo_metadata (meta_key, meta_value) VALUES (?,?);",
+			"matcher", cfgset.Matcher)
+		if err != nil {
+			return err
+		}
+	}
 
 	for _, t := range typeSet {
 		_, err = db.Exec("INSERT INTO recongo_types (type_id,type_name,type_description,type_url) VALUES (?,?,?,?);",
@@ -314,8 +602,8 @@ func outputToSqlite(filename string, typeSet []map[string]string, cfgset *inputC
 
 	for propID, etypes := range propSet {
 		fancyName := strings.Title(strings.TrimSpace(seps.ReplaceAllString(propID, " ")))
-		_, err = db.Exec("INSERT INTO recongo_properties (prop_id,prop_name) VALUES (?,?);",
-			propID, fancyName)
+		_, err = db.Exec("INSERT INTO recongo_properties (prop_id,prop_name,prop_type) VALUES (?,?,?);",
+			propID, fancyName, cfgset.PropertyTypes[propID])
 		if err != nil {
 			return err
 		}
@@ -335,8 +623,8 @@ func outputToSqlite(filename string, typeSet []map[string]string, cfgset *inputC
 	if err != nil {
 		return err
 	}
-	stmt, err := tx.Prepare(`INSERT INTO recongo_entities (ent_id, ent_name, ent_types, ent_description)
-	VALUES (?,?,?,?);`)
+	stmt, err := tx.Prepare(`INSERT INTO recongo_entities (ent_id, ent_name, ent_types, ent_description, ent_aliases)
+	VALUES (?,?,?,?,?);`)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -347,96 +635,120 @@ func outputToSqlite(filename string, typeSet []map[string]string, cfgset *inputC
 		tx.Rollback()
 		return err
 	}
+	stmt3, err := tx.Prepare(`INSERT INTO recongo_replaced (type_id, old_id, new_id)
+		VALUES (?,?,?);`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 
 	fmt.Fprint(os.Stderr, "Saving to database...\n")
 	nrec := 0
-	x := make(map[string]string, 20)
 	for s.Scan() {
 		nrec++
 		fmt.Fprintf(os.Stderr, "  %10d\r", nrec)
 		os.Stderr.Sync()
 
 		rec := strings.Split(s.Text(), "\t")
-		desc := ""
-		if rec[3] != "{}" {
-			json.Unmarshal([]byte(rec[3]), &x)
-			if d, ok := x["description"]; ok {
-				desc = d
-				delete(x, "description")
+		if typeIDs, ok := replacedTypes(rec[2]); ok {
+			for _, typeID := range typeIDs {
+				_, err = stmt3.Exec(typeID, rec[0], rec[1])
+				if err != nil {
+					stmt.Close()
+					stmt2.Close()
+					stmt3.Close()
+					tx.Rollback()
+					return err
+				}
 			}
-			if len(x) > 0 {
-				for propID, propVal := range x {
-					_, err = stmt2.Exec(rec[2], rec[0], propID, propVal)
-					if err != nil {
-						stmt.Close()
-						stmt2.Close()
-						tx.Rollback()
-						return err
-					}
-					delete(x, propID)
+			continue
+		}
+
+		desc, aliases, props := parseEntityProps(rec[3])
+		for propID, vals := range props {
+			for _, v := range vals {
+				_, err = stmt2.Exec(propertyTypes(rec[2]), rec[0], propID, v)
+				if err != nil {
+					stmt.Close()
+					stmt2.Close()
+					stmt3.Close()
+					tx.Rollback()
+					return err
 				}
-				// recongo_entity_properties
 			}
 		}
-		_, err = stmt.Exec(rec[0], rec[1], rec[2], desc)
+		_, err = stmt.Exec(rec[0], rec[1], rec[2], desc, aliases)
 		if err != nil {
 			stmt.Close()
 			stmt2.Close()
+			stmt3.Close()
 			tx.Rollback()
 			return err
 		}
 	}
 	stmt.Close()
 	stmt2.Close()
-	_, err = tx.Exec("INSERT INTO recongo_entities_fts(recongo_entities_fts) VALUES ('rebuild');")
-	if err != nil {
-		tx.Rollback()
-		return err
+	stmt3.Close()
+	for _, ddl := range model.SQLiteIndexes {
+		_, err = tx.Exec(ddl)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 	return tx.Commit()
 }
 
-var schema = []string{
-	`CREATE TABLE recongo_metadata (
-		meta_key varchar primary key,
-		meta_value varchar
-	);`,
-
-	`CREATE TABLE recongo_types (
-		type_id varchar primary key,
-		type_name varchar,
-		type_description varchar,
-		type_url varchar
-	);`,
-
-	`CREATE TABLE recongo_properties (
-		prop_id varchar primary key,
-		prop_name varchar,
-		prop_description varchar
-	);`,
-
-	`CREATE TABLE recongo_props2types (
-		prop_id varchar references recongo_properties (prop_id),
-		type_id vachar references recongo_types (type_id),
-		primary key (prop_id, type_id)
-	);`,
-
-	`CREATE TABLE recongo_entities (
-		ent_types varchar, -- comma-separated list of type_ids
-		ent_id varchar,
-		ent_name varchar,
-		ent_description varchar,
-		primary key(ent_id, ent_types)
-	);`,
-
-	`CREATE TABLE recongo_entity_properties (
-		ent_types varchar,
-		ent_id varchar,
-		prop_id varchar,
-		prop_value varchar,
-		primary key (ent_types,ent_id,prop_id,prop_value)
-	)`,
-
-	`CREATE VIRTUAL TABLE recongo_entities_fts USING fts5
-		(ent_id, ent_name, ent_description, ent_types, content=recongo_entities);`,
+// replacedTypes returns the Type IDs of a row mapping a retired ID to its
+// replacement, or false if the row is an entity.
+func replacedTypes(types string) ([]string, bool) {
+	if !strings.HasPrefix(types, replacedByType+",") {
+		return nil, false
+	}
+	return strings.Split(types[len(replacedByType)+1:], ","), true
+}
+
+// propertyTypes returns the Type ID that the properties of an entity are
+// stored under: the first of its Types, which is also used in its ID. This
+// keeps property lookups on the primary key for entities with many Types.
+func propertyTypes(types string) string {
+	if i := strings.IndexByte(types, ','); i >= 0 {
+		return types[:i]
+	}
+	return types
+}
+
+// parseEntityProps parses the JSON properties of an entity row, and returns
+// the description and the list of unique values for each other property.
+// Aliases are also returned as a newline-separated list.
+func parseEntityProps(raw string) (string, string, map[string][]string) {
+	if raw == "{}" {
+		return "", "", nil
+	}
+	x := make(map[string]interface{})
+	json.Unmarshal([]byte(raw), &x)
+
+	desc := ""
+	if d, ok := x["description"]; ok {
+		desc = fmt.Sprint(d)
+		delete(x, "description")
+	}
+	props := make(map[string][]string, len(x))
+	for propID, propVal := range x {
+		// one value per row for multi-valued properties
+		vals, ok := propVal.([]interface{})
+		if !ok {
+			vals = []interface{}{propVal}
+		}
+		seen := make(map[string]struct{}, len(vals))
+		for _, v := range vals {
+			sv := fmt.Sprint(v)
+			if _, dup := seen[sv]; dup {
+				continue
+			}
+			seen[sv] = struct{}{}
+			props[propID] = append(props[propID], sv)
+		}
+	}
+	return desc, strings.Join(props["aliases"], "\n"), props
 }
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		err  string
+	}{
+		{"type id", `{"files": [{"id": "gene", "filename": "gene_info"}]}`, ""},
+		{"type column", `{"files": [{"type_column": "type_of_gene", "filename": "gene_info"}]}`, ""},
+		{"no type", `{"files": [{"id": "gene", "filename": "gene_info"}, {"filename": "gene2go"}]}`,
+			`gene2go: file needs a type ID ("id") or a "type_column"`},
+		{"history type", `{"files": [{"id": "gene", "filename": "gene_info"}],
+			"history": [{"filename": "gene_history"}]}`, ""},
+		{"no history type", `{"files": [{"type_column": "type_of_gene", "filename": "gene_info"}],
+			"history": [{"filename": "gene_history"}]}`, "gene_history: history needs a type"},
+		{"invalid", `{"files": [`, "unexpected EOF"},
+	}
+	for _, tc := range tests {
+		f, err := ioutil.TempFile("", "data4recon.*.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.cfg)
+		f.Close()
+		cfg, err := loadConfig(f.Name())
+		os.Remove(f.Name())
+
+		if tc.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tc.name, err)
+			} else if len(cfg.History) > 0 && cfg.History[0].Type != "gene" {
+				t.Errorf("%s: history type = %q, want gene", tc.name, cfg.History[0].Type)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error = %v, want %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	const header = "#tax_id\tGeneID\tDiscontinued_GeneID\n"
+	tests := []struct {
+		name   string
+		data   string
+		oldCol int
+		newCol int
+		dryRun bool
+		want   string
+		err    string
+	}{
+		{"replaced", header + "9606\t672\t100\n9606\t7157\t101\n", 2, 1, false,
+			"100\t672\treplaced_by,gene\t{}\n101\t7157\treplaced_by,gene\t{}\n", ""},
+		// discontinued without a replacement
+		{"blank new id", header + "9606\t-\t100\n9606\t\t101\n", 2, 1, false, "", ""},
+		{"blank old id", header + "9606\t672\t-\n", 2, 1, false, "", ""},
+		{"same id", header + "9606\t672\t672\n", 2, 1, false, "", ""},
+		// the first replacement of an ID is kept
+		{"duplicate", header + "9606\t672\t100\n9606\t7157\t100\n", 2, 1, false,
+			"100\t672\treplaced_by,gene\t{}\n", ""},
+		{"header only", header, 2, 1, false, "", ""},
+		{"dry run", header + "9606\t672\t100\n", 2, 1, true, "", ""},
+		{"empty", "", 2, 1, false, "", "cannot read the header"},
+		{"bad old column", header, 3, 1, false, "", "column 3 does not exist"},
+		{"bad new column", header, 2, -1, false, "", "column -1 does not exist"},
+		{"short row", header + "9606\t672\n", 2, 1, false, "", "wrong number of fields"},
+	}
+	for _, tc := range tests {
+		f, err := ioutil.TempFile("", "gene_history.*.tsv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(tc.data)
+		f.Close()
+		hc := HistoryConfig{Type: "gene", Filename: f.Name(), OldIDColumn: tc.oldCol, NewIDColumn: tc.newCol}
+		var out strings.Builder
+		err = readHistory(&out, hc, tc.dryRun)
+		os.Remove(f.Name())
+
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: error = %v, want %q", tc.name, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+		} else if out.String() != tc.want {
+			t.Errorf("%s: output = %q, want %q", tc.name, out.String(), tc.want)
+		}
+	}
+}
+
+func TestReplacedTypes(t *testing.T) {
+	tests := []struct {
+		types string
+		want  []string
+		ok    bool
+	}{
+		{"replaced_by,gene", []string{"gene"}, true},
+		{"replaced_by,gene,protein-coding", []string{"gene", "protein-coding"}, true},
+		{"gene", nil, false},
+		{"gene,replaced_by", nil, false},
+		{"replaced_by", nil, false},
+		{"", nil, false},
+	}
+	for _, tc := range tests {
+		got, ok := replacedTypes(tc.types)
+		if ok != tc.ok || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("replacedTypes(%q) = %v, %v, want %v, %v", tc.types, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestParseEntityProps(t *testing.T) {
+	tests := []struct {
+		raw     string
+		desc    string
+		aliases string
+		props   map[string][]string
+	}{
+		{"{}", "", "", nil},
+		{`{"description":"tumor protein p53"}`, "tumor protein p53", "", map[string][]string{}},
+		{`{"aliases":"P53"}`, "", "P53", map[string][]string{"aliases": {"P53"}}},
+		{`{"aliases":["P53","LFS1","P53"],"tax_id":"9606"}`, "", "P53\nLFS1",
+			map[string][]string{"aliases": {"P53", "LFS1"}, "tax_id": {"9606"}}},
+		{`{"tax_id":9606,"go":["GO:1","GO:2"]}`, "", "",
+			map[string][]string{"tax_id": {"9606"}, "go": {"GO:1", "GO:2"}}},
+		// invalid JSON has no properties
+		{`{"tax_id":`, "", "", map[string][]string{}},
+	}
+	for _, tc := range tests {
+		desc, aliases, props := parseEntityProps(tc.raw)
+		if desc != tc.desc || aliases != tc.aliases || !reflect.DeepEqual(props, tc.props) {
+			t.Errorf("parseEntityProps(%s) = %q, %q, %v, want %q, %q, %v",
+				tc.raw, desc, aliases, props, tc.desc, tc.aliases, tc.props)
+		}
+	}
+}
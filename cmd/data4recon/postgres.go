@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/joiningdata/recongo/model"
+)
+
+// outputToPostgres writes a SQL script that creates and loads a postgres
+// database, e.g.:
+//
+//	psql -v ON_ERROR_STOP=1 -d dbname -f output.sql
+func outputToPostgres(dest io.WriteCloser, typeSet []map[string]string, cfgset *inputConfig,
+	propSet map[string]map[string]struct{}, s *bufio.Scanner) error {
+
+	w := bufio.NewWriter(dest)
+	fmt.Fprintln(w, "BEGIN;")
+	fmt.Fprintln(w, "CREATE EXTENSION IF NOT EXISTS pg_trgm;")
+	for _, ddl := range model.PostgresSchema {
+		fmt.Fprintln(w, ddl)
+	}
+
+	////
+	// add in the global metadata
+	fmt.Fprintln(w, "COPY recongo_metadata (meta_key, meta_value) FROM stdin;")
+	copyRow(w, "name", cfgset.Name)
+	copyRow(w, "identifierNamespace", cfgset.IdentifierNamespace)
+	copyRow(w, "schemaNamespace", cfgset.SchemaNamespace)
+	copyRow(w, "view_url", cfgset.ViewURL)
+	if cfgset.Matcher != "" {
+		copyRow(w, "matcher", cfgset.Matcher)
+	}
+	fmt.Fprintln(w, `\.`)
+
+	fmt.Fprintln(w, "COPY recongo_types (type_id, type_name, type_description, type_url) FROM stdin;")
+	for _, t := range typeSet {
+		copyRow(w, t["id"], t["name"], t["description"], t["url"])
+	}
+	fmt.Fprintln(w, `\.`)
+
+	fmt.Fprintln(w, "COPY recongo_properties (prop_id, prop_name, prop_type) FROM stdin;")
+	for propID := range propSet {
+		fancyName := strings.Title(strings.TrimSpace(seps.ReplaceAllString(propID, " ")))
+		copyRow(w, propID, fancyName, cfgset.PropertyTypes[propID])
+	}
+	fmt.Fprintln(w, `\.`)
+
+	fmt.Fprintln(w, "COPY recongo_props2types (prop_id, type_id) FROM stdin;")
+	for propID, etypes := range propSet {
+		for typeID := range etypes {
+			copyRow(w, propID, typeID)
+		}
+	}
+	fmt.Fprintln(w, `\.`)
+
+	////
+	// entity properties and retired ids are collected separately,
+	// since they are loaded after all of the entities
+	ptmp, err := ioutil.TempFile("", "data4recon.*.props")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		ptmp.Close()
+		os.Remove(ptmp.Name())
+	}()
+	pw := bufio.NewWriter(ptmp)
+	htmp, err := ioutil.TempFile("", "data4recon.*.history")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		htmp.Close()
+		os.Remove(htmp.Name())
+	}()
+	hw := bufio.NewWriter(htmp)
+
+	fmt.Fprint(os.Stderr, "Writing SQL script...\n")
+	nrec := 0
+	fmt.Fprintln(w, "COPY recongo_entities (ent_types, ent_id, ent_name, ent_description, ent_aliases) FROM stdin;")
+	for s.Scan() {
+		nrec++
+		fmt.Fprintf(os.Stderr, "  %10d\r", nrec)
+		os.Stderr.Sync()
+
+		rec := strings.Split(s.Text(), "\t")
+		if typeIDs, ok := replacedTypes(rec[2]); ok {
+			for _, typeID := range typeIDs {
+				copyRow(hw, typeID, rec[0], rec[1])
+			}
+			continue
+		}
+		desc, aliases, props := parseEntityProps(rec[3])
+		copyRow(w, rec[2], rec[0], rec[1], desc, aliases)
+		for propID, vals := range props {
+			for _, v := range vals {
+				copyRow(pw, propertyTypes(rec[2]), rec[0], propID, v)
+			}
+		}
+	}
+	fmt.Fprintln(w, `\.`)
+
+	if err = pw.Flush(); err != nil {
+		return err
+	}
+	if _, err = ptmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "COPY recongo_entity_properties (ent_types, ent_id, prop_id, prop_value) FROM stdin;")
+	if _, err = io.Copy(w, ptmp); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, `\.`)
+
+	if err = hw.Flush(); err != nil {
+		return err
+	}
+	if _, err = htmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "COPY recongo_replaced (type_id, old_id, new_id) FROM stdin;")
+	if _, err = io.Copy(w, htmp); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, `\.`)
+
+	for _, ddl := range model.PostgresIndexes {
+		fmt.Fprintln(w, ddl)
+	}
+	fmt.Fprintln(w, "COMMIT;")
+
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	return dest.Close()
+}
+
+// escapes values for the postgres COPY text format
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// copyRow writes a tab-delimited row in the postgres COPY text format.
+func copyRow(w io.Writer, vals ...string) {
+	for i, v := range vals {
+		vals[i] = copyEscaper.Replace(v)
+	}
+	fmt.Fprintln(w, strings.Join(vals, "\t"))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/joiningdata/recongo/model"
+)
+
+// nopCloser adds a Close method to a buffer.
+type nopCloser struct {
+	*bytes.Buffer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestCopyRow(t *testing.T) {
+	var buf bytes.Buffer
+	copyRow(&buf, "672", "BRCAI\nIRIS", `C:\genes`, "a\tb", "")
+	want := "672\tBRCAI\\nIRIS\tC:\\\\genes\ta\\tb\t\n"
+	if got := buf.String(); got != want {
+		t.Errorf("copyRow = %q, want %q", got, want)
+	}
+}
+
+func TestOutputToPostgres(t *testing.T) {
+	typeSet := []map[string]string{{"id": "gene", "name": "Gene", "description": "", "url": ""}}
+	cfgset := &inputConfig{Name: "Genes", IdentifierNamespace: "ncbi", SchemaNamespace: "ncbi",
+		PropertyTypes: map[string]string{"tax_id": "int"}}
+	propSet := map[string]map[string]struct{}{"tax_id": {"gene": {}}}
+	rows := strings.Join([]string{
+		"672\tBRCA1\tgene,protein-coding\t" + `{"description":"DNA repair","aliases":["BRCAI","IRIS"],"tax_id":"9606"}`,
+		"7157\tTP53\tgene\t{}",
+		"100\t672\treplaced_by,gene",
+	}, "\n")
+
+	var buf bytes.Buffer
+	err := outputToPostgres(nopCloser{&buf}, typeSet, cfgset, propSet, bufio.NewScanner(strings.NewReader(rows)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	// each section must follow the one before it
+	sections := []string{"BEGIN;\n", "CREATE EXTENSION IF NOT EXISTS pg_trgm;\n"}
+	sections = append(sections, model.PostgresSchema...)
+	sections = append(sections,
+		"COPY recongo_metadata (meta_key, meta_value) FROM stdin;\nname\tGenes\nidentifierNamespace\tncbi\n",
+		"COPY recongo_types (type_id, type_name, type_description, type_url) FROM stdin;\ngene\tGene\t\t\n\\.\n",
+		"COPY recongo_properties (prop_id, prop_name, prop_type) FROM stdin;\ntax_id\tTax Id\tint\n\\.\n",
+		"COPY recongo_props2types (prop_id, type_id) FROM stdin;\ntax_id\tgene\n\\.\n",
+		"COPY recongo_entities (ent_types, ent_id, ent_name, ent_description, ent_aliases) FROM stdin;\n"+
+			"gene,protein-coding\t672\tBRCA1\tDNA repair\tBRCAI\\nIRIS\n"+
+			"gene\t7157\tTP53\t\t\n\\.\n",
+		// properties are stored under the first type
+		"COPY recongo_entity_properties (ent_types, ent_id, prop_id, prop_value) FROM stdin;\n",
+		"COPY recongo_replaced (type_id, old_id, new_id) FROM stdin;\ngene\t100\t672\n\\.\n",
+	)
+	sections = append(sections, model.PostgresIndexes...)
+	sections = append(sections, "COMMIT;\n")
+	rest := out
+	for _, s := range sections {
+		i := strings.Index(rest, s)
+		if i < 0 {
+			t.Fatalf("missing or out of order: %q\nin:\n%s", s, out)
+		}
+		rest = rest[i+len(s):]
+	}
+	for _, row := range []string{
+		"gene\t672\taliases\tBRCAI\n", "gene\t672\taliases\tIRIS\n", "gene\t672\ttax_id\t9606\n",
+	} {
+		if !strings.Contains(out, row) {
+			t.Errorf("missing entity property row %q", row)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Transform is a step in the pipeline of transforms applied to each value
+// of a column, e.g. {"op":"strip_prefix","arg":"HGNC:"}.
+type Transform struct {
+	// Op is one of trim, lower, upper, extract, replace, split, null,
+	// strip_prefix or date.
+	Op string `json:"op"`
+
+	// Arg is the regular expression (extract and replace), the delimiter
+	// (split), the null marker (null), the prefix (strip_prefix) or the
+	// layout of the input (date, using Go's reference time).
+	Arg string `json:"arg,omitempty"`
+
+	// With is the replacement (replace, may use $1 etc.) or the layout of
+	// the output (date, defaults to 2006-01-02).
+	With string `json:"with,omitempty"`
+}
+
+func (t Transform) String() string {
+	switch {
+	case t.With != "":
+		return fmt.Sprintf("%s(%s, %s)", t.Op, t.Arg, t.With)
+	case t.Arg != "":
+		return fmt.Sprintf("%s(%s)", t.Op, t.Arg)
+	}
+	return t.Op
+}
+
+// transformFunc transforms a value into zero or more values.
+type transformFunc func(v string) []string
+
+func (t Transform) compile() (transformFunc, error) {
+	switch t.Op {
+	case "trim":
+		return func(v string) []string {
+			return []string{strings.TrimSpace(v)}
+		}, nil
+	case "lower":
+		return func(v string) []string {
+			return []string{strings.ToLower(v)}
+		}, nil
+	case "upper":
+		return func(v string) []string {
+			return []string{strings.ToUpper(v)}
+		}, nil
+
+	case "extract":
+		// keeps the first group of the match, or the whole match
+		re, err := regexp.Compile(t.Arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(v string) []string {
+			m := re.FindStringSubmatch(v)
+			if m == nil {
+				return nil
+			}
+			if len(m) > 1 {
+				return m[1:2]
+			}
+			return m[:1]
+		}, nil
+	case "replace":
+		re, err := regexp.Compile(t.Arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(v string) []string {
+			return []string{re.ReplaceAllString(v, t.With)}
+		}, nil
+
+	case "split":
+		if t.Arg == "" {
+			return nil, fmt.Errorf("split needs a delimiter")
+		}
+		return func(v string) []string {
+			return strings.Split(v, t.Arg)
+		}, nil
+	case "null":
+		return func(v string) []string {
+			if v == t.Arg {
+				return nil
+			}
+			return []string{v}
+		}, nil
+	case "strip_prefix":
+		return func(v string) []string {
+			return []string{strings.TrimPrefix(v, t.Arg)}
+		}, nil
+
+	case "date":
+		if t.Arg == "" {
+			return nil, fmt.Errorf("date needs an input layout")
+		}
+		layout := t.With
+		if layout == "" {
+			layout = "2006-01-02"
+		}
+		// values that are not dates are dropped
+		return func(v string) []string {
+			d, err := time.Parse(t.Arg, v)
+			if err != nil {
+				return nil
+			}
+			return []string{d.Format(layout)}
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown transform '%s'", t.Op)
+}
+
+// pipeline is a compiled list of transforms.
+type pipeline struct {
+	steps []Transform
+	funcs []transformFunc
+}
+
+func compilePipeline(ts []Transform) (*pipeline, error) {
+	p := &pipeline{steps: ts}
+	for _, t := range ts {
+		f, err := t.compile()
+		if err != nil {
+			return nil, fmt.Errorf("transform %s: %v", t, err)
+		}
+		p.funcs = append(p.funcs, f)
+	}
+	return p, nil
+}
+
+func (p *pipeline) String() string {
+	if p == nil {
+		return ""
+	}
+	parts := make([]string, len(p.steps))
+	for i, t := range p.steps {
+		parts[i] = t.String()
+	}
+	return strings.Join(parts, " | ")
+}
+
+// apply runs each value through the pipeline, and returns the non-blank
+// values left at the end. A nil pipeline only removes blank values.
+func (p *pipeline) apply(vals []string) []string {
+	var funcs []transformFunc
+	if p != nil {
+		funcs = p.funcs
+	}
+	for _, f := range funcs {
+		var next []string
+		for _, v := range vals {
+			next = append(next, f(v)...)
+		}
+		vals = next
+	}
+	res := vals[:0]
+	for _, v := range vals {
+		if !isBlank(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
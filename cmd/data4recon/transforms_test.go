@@ -0,0 +1,106 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		t    Transform
+		in   string
+		want []string
+	}{
+		{Transform{Op: "trim"}, "  BRCA1 ", []string{"BRCA1"}},
+		{Transform{Op: "lower"}, "BRCA1", []string{"brca1"}},
+		{Transform{Op: "upper"}, "brca1", []string{"BRCA1"}},
+		{Transform{Op: "extract", Arg: `HGNC:(\d+)`}, "MIM:113705|HGNC:1100", []string{"1100"}},
+		{Transform{Op: "extract", Arg: `\d+`}, "chr17q21", []string{"17"}},
+		{Transform{Op: "extract", Arg: `HGNC:(\d+)`}, "MIM:113705", nil},
+		{Transform{Op: "replace", Arg: `^chr(\w+)$`, With: "$1"}, "chr17", []string{"17"}},
+		{Transform{Op: "replace", Arg: `\s+`, With: " "}, "tumor   protein", []string{"tumor protein"}},
+		{Transform{Op: "split", Arg: ";"}, "a;b;;c", []string{"a", "b", "", "c"}},
+		{Transform{Op: "null", Arg: "NA"}, "NA", nil},
+		{Transform{Op: "null", Arg: "NA"}, "NAT1", []string{"NAT1"}},
+		{Transform{Op: "strip_prefix", Arg: "HGNC:"}, "HGNC:1100", []string{"1100"}},
+		{Transform{Op: "strip_prefix", Arg: "HGNC:"}, "MIM:113705", []string{"MIM:113705"}},
+		{Transform{Op: "date", Arg: "20060102"}, "20201016", []string{"2020-10-16"}},
+		{Transform{Op: "date", Arg: "2006-01-02", With: "02 Jan 2006"}, "2020-10-16", []string{"16 Oct 2020"}},
+		{Transform{Op: "date", Arg: "20060102"}, "-", nil},
+	}
+	for _, tc := range tests {
+		f, err := tc.t.compile()
+		if err != nil {
+			t.Errorf("%s: %v", tc.t, err)
+			continue
+		}
+		if got := f(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s(%q) = %q, want %q", tc.t, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestTransformErrors(t *testing.T) {
+	tests := []struct {
+		t    Transform
+		want string
+	}{
+		{Transform{Op: "reverse"}, "unknown transform"},
+		{Transform{Op: "extract", Arg: "("}, "missing closing )"},
+		{Transform{Op: "replace", Arg: "[a-"}, "missing closing ]"},
+		{Transform{Op: "split"}, "needs a delimiter"},
+		{Transform{Op: "date"}, "needs an input layout"},
+	}
+	for _, tc := range tests {
+		_, err := tc.t.compile()
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: error = %v, want %q", tc.t, err, tc.want)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		ts   []Transform
+		in   []string
+		want []string
+		str  string
+	}{
+		// a nil pipeline only removes blank values
+		{nil, []string{"a", "", "-", "b"}, []string{"a", "b"}, ""},
+		{
+			// steps run in order, so the prefix is stripped after upper
+			[]Transform{{Op: "split", Arg: "|"}, {Op: "trim"}, {Op: "upper"}, {Op: "strip_prefix", Arg: "HGNC:"}},
+			[]string{"hgnc:1100 | MIM:113705", " - "},
+			[]string{"1100", "MIM:113705"},
+			"split(|) | trim | upper | strip_prefix(HGNC:)",
+		},
+		{
+			[]Transform{{Op: "null", Arg: "NA"}, {Op: "extract", Arg: `^(\d+)`}},
+			[]string{"NA", "12 kb", "none"},
+			[]string{"12"},
+			"null(NA) | extract(^(\\d+))",
+		},
+	}
+	for _, tc := range tests {
+		var p *pipeline
+		if tc.ts != nil {
+			var err error
+			if p, err = compilePipeline(tc.ts); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := p.apply(tc.in); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: apply(%q) = %q, want %q", p, tc.in, got, tc.want)
+		}
+		if got := p.String(); got != tc.str {
+			t.Errorf("String() = %q, want %q", got, tc.str)
+		}
+	}
+
+	_, err := compilePipeline([]Transform{{Op: "trim"}, {Op: "split"}})
+	if err == nil || !strings.Contains(err.Error(), "transform split: split needs a delimiter") {
+		t.Errorf("compilePipeline error = %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// authorized checks the bearer token of an admin request.
+func authorized(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	given := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// reloadHandler returns an admin handler to reload the services in the
+// background. The "prefix" parameter restricts it to a single service.
+func reloadHandler(reloaders []*reloader, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		prefix := r.FormValue("prefix")
+		n := 0
+		for _, rl := range reloaders {
+			if prefix == "" || prefix == rl.config.Prefix {
+				go rl.Reload()
+				n++
+			}
+		}
+		if n == 0 {
+			http.Error(w, "no service at prefix: "+prefix, http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// shutdownHandler returns an admin handler to gracefully shut down the
+// server, by signalling the stop channel.
+func shutdownHandler(stop chan<- os.Signal, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorized(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		select {
+		case stop <- syscall.SIGTERM:
+		default:
+			// already shutting down
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
@@ -1,22 +1,162 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime/pprof"
-	"sync"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/joiningdata/recongo/api"
 	"github.com/joiningdata/recongo/model"
 )
 
+// serverConfig describes a set of reconciliation services to serve.
+type serverConfig struct {
+	// PublicURL is the public-accessible address root (overrides -h).
+	PublicURL string `json:"public_url"`
+
+	// Listen is the port:address to listen for http requests (overrides -i).
+	Listen string `json:"listen"`
+
+	// WatchInterval is how often to check source files for changes,
+	// e.g. "30s" (overrides -watch).
+	WatchInterval string `json:"watch_interval,omitempty"`
+
+	// AdminToken enables the admin endpoints, which require it as a
+	// bearer token (overrides -admin-token).
+	AdminToken string `json:"admin_token,omitempty"`
+
+	// Services lists each reconciliation service to mount.
+	Services []serviceConfig `json:"services"`
+}
+
+// serviceConfig describes a single reconciliation service.
+type serviceConfig struct {
+	// Prefix is the URL prefix to serve requests from.
+	Prefix string `json:"prefix"`
+
+	// Name of the service when federating several data sources.
+	Name string `json:"name,omitempty"`
+
+	// Matcher is the fuzzy matching algorithm to use (optional).
+	Matcher string `json:"matcher,omitempty"`
+
+	// PreviewTemplate is an html template file to render entity previews (optional).
+	PreviewTemplate string `json:"preview_template,omitempty"`
+
+	// Concurrency is the number of batch queries to run concurrently (optional).
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// QueryTimeout is the time to wait for each query, e.g. "10s" (optional).
+	QueryTimeout string `json:"query_timeout,omitempty"`
+
+	// Lenient skips invalid rows in flat files instead of failing to load.
+	Lenient bool `json:"lenient,omitempty"`
+
+	// Sources lists the data source filenames to serve.
+	Sources []string `json:"sources"`
+}
+
+func loadConfig(fn string) (*serverConfig, error) {
+	cfg := &serverConfig{}
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	err = json.NewDecoder(f).Decode(cfg)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return cfg, f.Close()
+}
+
+// loadSource loads the data source files, federating them into
+// a single Source if there is more than one.
+func loadSource(sc serviceConfig) (model.Source, error) {
+	mode := model.LoadStrict
+	if sc.Lenient {
+		mode = model.LoadLenient
+	}
+	// check the matcher first, so that no source is left open if it is unknown
+	m, err := model.GetMatcher(sc.Matcher)
+	if err != nil {
+		return nil, err
+	}
+
+	var src model.Source
+	if len(sc.Sources) <= 1 {
+		fn := ""
+		if len(sc.Sources) == 1 {
+			fn = sc.Sources[0]
+		}
+		one, err := model.LoadWithMode(fn, mode)
+		if err != nil {
+			return nil, err
+		}
+		src = one
+	} else {
+		// federate all the data sources behind one service
+		var srcs []model.Source
+		var names []string
+		for _, fn := range sc.Sources {
+			one, err := model.LoadWithMode(fn, mode)
+			if err != nil {
+				// close the sources already loaded
+				for _, s := range srcs {
+					closeSource(s)
+				}
+				return nil, err
+			}
+			srcs = append(srcs, one)
+			names = append(names, one.Name())
+		}
+		if sc.Name == "" {
+			sc.Name = strings.Join(names, ", ")
+		}
+		src = model.NewFederatedSource(sc.Name, "", "", srcs...)
+	}
+
+	if sc.Matcher != "" {
+		if fs, ok := src.(interface{ SetMatcher(model.Matcher) }); ok {
+			fs.SetMatcher(m)
+		}
+	}
+	return src, nil
+}
+
+// closeSource closes the data source if it holds any resources.
+func closeSource(src model.Source) error {
+	if c, ok := src.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 func main() {
 	publicURL := flag.String("h", "http://127.0.0.1:8080", "public-accessible address root")
 	prefix := flag.String("p", "/api", "URL `prefix` to serve requests from")
 	addr := flag.String("i", ":8080", "`port:address` to listen for http requests")
 	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to file")
+	name := flag.String("n", "", "`name` of the service when serving several data sources")
+	fuzzy := flag.String("m", "", "fuzzy `matcher` to use (levenshtein, jaro-winkler, trigram or none)")
+	configFile := flag.String("c", "", "json `config` file listing the services to serve")
+	previewTemplate := flag.String("t", "", "html `template` file to render entity previews")
+	concurrency := flag.Int("j", api.DefaultConcurrency, "`number` of batch queries to run concurrently")
+	lenient := flag.Bool("lenient", false, "skip invalid rows in flat files instead of failing to load")
+	queryTimeout := flag.Duration("qt", api.DefaultQueryTimeout, "`timeout` for each query")
+	watchInterval := flag.Duration("watch", 0, "`interval` to check source files for changes and reload them (0 disables)")
+	adminToken := flag.String("admin-token", "", "bearer `token` to enable the admin endpoints")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "`timeout` to wait for requests to finish when shutting down")
 	flag.Parse()
 
 	if *cpuprofile != "" {
@@ -28,28 +168,104 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	src, err := model.Load(flag.Arg(0))
-	if err != nil {
-		log.Fatal(flag.Arg(0), err)
+	cfg := &serverConfig{
+		Services: []serviceConfig{{
+			Prefix:  *prefix,
+			Name:    *name,
+			Matcher: *fuzzy,
+			Sources: flag.Args(),
+
+			PreviewTemplate: *previewTemplate,
+			Concurrency:     *concurrency,
+			QueryTimeout:    queryTimeout.String(),
+			Lenient:         *lenient,
+		}},
+	}
+	if *configFile != "" {
+		var err error
+		cfg, err = loadConfig(*configFile)
+		if err != nil {
+			log.Fatal(*configFile, err)
+		}
+	}
+	if cfg.PublicURL != "" {
+		*publicURL = cfg.PublicURL
+	}
+	if cfg.Listen != "" {
+		*addr = cfg.Listen
+	}
+	if cfg.WatchInterval != "" {
+		var err error
+		*watchInterval, err = time.ParseDuration(cfg.WatchInterval)
+		if err != nil {
+			log.Fatal(cfg.WatchInterval, err)
+		}
+	}
+	if cfg.AdminToken != "" {
+		*adminToken = cfg.AdminToken
+	}
+
+	index := api.NewIndex(*publicURL)
+	var reloaders []*reloader
+	for _, sc := range cfg.Services {
+		src, err := loadSource(sc)
+		if err != nil {
+			log.Fatal(sc.Sources, err)
+		}
+		service, err := index.Mount(sc.Prefix, src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if sc.PreviewTemplate != "" {
+			err = service.SetPreviewTemplate(sc.PreviewTemplate)
+			if err != nil {
+				log.Fatal(sc.PreviewTemplate, err)
+			}
+		}
+		var timeout time.Duration
+		if sc.QueryTimeout != "" {
+			timeout, err = time.ParseDuration(sc.QueryTimeout)
+			if err != nil {
+				log.Fatal(sc.QueryTimeout, err)
+			}
+		}
+		service.SetQueryLimits(sc.Concurrency, timeout)
+		reloaders = append(reloaders, newReloader(service, sc, src))
+		log.Println("Serving " + src.Name() + " at " + *publicURL + sc.Prefix)
 	}
 
-	wg := sync.WaitGroup{}
+	go reloadOnHangup(reloaders)
+	if *watchInterval > 0 {
+		log.Println("Watching source files for changes every", *watchInterval)
+		go watch(reloaders, *watchInterval)
+	}
+	if *adminToken != "" {
+		index.HandleFunc("/admin/reload", reloadHandler(reloaders, *adminToken))
+	}
 
-	service := api.NewService(*publicURL, *prefix, src)
-	service.HandleFunc("/quit", func(w http.ResponseWriter, r *http.Request) {
-		wg.Done()
-	})
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	if *adminToken != "" {
+		index.HandleFunc("/admin/shutdown", shutdownHandler(stop, *adminToken))
+	}
 
-	wg.Add(1)
+	server := &http.Server{Addr: *addr, Handler: index}
 	go func() {
-		log.Println("Listening at " + *publicURL + *prefix)
-		log.Println("  Load " + *publicURL + "/quit to force a clean shutdown")
-		err = http.ListenAndServe(*addr, service)
-		if err != nil {
+		log.Println("Listening at " + *publicURL)
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
-		wg.Done()
 	}()
 
-	wg.Wait()
+	<-stop
+	log.Println("Shutting down, waiting up to", *shutdownTimeout, "for requests to finish")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println(err)
+	}
+	for _, r := range reloaders {
+		r.Close()
+	}
 }
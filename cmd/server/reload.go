@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joiningdata/recongo/api"
+	"github.com/joiningdata/recongo/model"
+)
+
+// reloader reloads the data source of a mounted service, swapping it in
+// only once it has loaded successfully.
+type reloader struct {
+	service *api.Service
+	config  serviceConfig
+
+	// only one reload at a time
+	mu     sync.Mutex
+	source model.Source
+
+	// modification times of the source files when last (re)loaded, and
+	// when last polled (used to wait for files to stop changing).
+	loaded map[string]time.Time
+	polled map[string]time.Time
+}
+
+func newReloader(service *api.Service, sc serviceConfig, src model.Source) *reloader {
+	r := &reloader{
+		service: service,
+		config:  sc,
+		source:  src,
+	}
+	r.loaded = r.modTimes()
+	r.polled = r.loaded
+	return r
+}
+
+// modTimes returns the modification times of the source files. Sources
+// that are not files (e.g. postgres connection strings) are not included.
+func (r *reloader) modTimes() map[string]time.Time {
+	res := make(map[string]time.Time)
+	for _, fn := range r.config.Sources {
+		if strings.Contains(fn, "://") {
+			continue
+		}
+		if fi, err := os.Stat(fn); err == nil {
+			res[fn] = fi.ModTime()
+		}
+	}
+	return res
+}
+
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for fn, t := range a {
+		if !t.Equal(b[fn]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Reload loads the data source again, and swaps it into the service. If
+// loading fails the error is logged and the current source is kept.
+func (r *reloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// files that fail to load are not retried until they change again
+	r.loaded = r.modTimes()
+	log.Println("Reloading", r.config.Sources)
+	src, err := loadSource(r.config)
+	if err != nil {
+		log.Println("Reload failed, keeping current source:", r.config.Sources, err)
+		return err
+	}
+	old, idle := r.service.SetSource(src)
+	r.source = src
+	log.Println("Reloaded " + src.Name() + " at " + r.config.Prefix)
+
+	// close the old source once the requests still using it finish
+	go func() {
+		idle()
+		if err := closeSource(old); err != nil {
+			log.Println(err)
+		}
+	}()
+	return nil
+}
+
+// Close waits for any reload in progress, and closes the current data source.
+func (r *reloader) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := closeSource(r.source); err != nil {
+		log.Println(err)
+	}
+}
+
+// poll reloads the data source if the source files have changed since it
+// was loaded, and have not changed again since the last poll.
+func (r *reloader) poll() {
+	mtimes := r.modTimes()
+	stable := sameModTimes(mtimes, r.polled)
+	r.polled = mtimes
+	if !stable {
+		return
+	}
+
+	r.mu.Lock()
+	changed := !sameModTimes(mtimes, r.loaded)
+	r.mu.Unlock()
+	if changed {
+		r.Reload()
+	}
+}
+
+// watch polls the source files of every service for changes.
+func watch(reloaders []*reloader, interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, r := range reloaders {
+			r.poll()
+		}
+	}
+}
+
+// reloadOnHangup reloads every service when the process receives a SIGHUP.
+func reloadOnHangup(reloaders []*reloader) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		for _, r := range reloaders {
+			go r.Reload()
+		}
+	}
+}
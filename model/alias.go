@@ -0,0 +1,91 @@
+package model
+
+import (
+	"strings"
+)
+
+const (
+	// exactAliasSimilarity is the name similarity of a candidate with an
+	// alias that is the query text.
+	exactAliasSimilarity = 0.9
+
+	// aliasWeight scales the similarity of a partial alias match, so that
+	// partially matching an entity's name ranks above its aliases.
+	aliasWeight = 0.9
+)
+
+// nameMatch describes how query text matched the names of an entity.
+type nameMatch int
+
+const (
+	partialMatch nameMatch = iota
+	exactAliasMatch
+	exactNameMatch
+)
+
+// matchNames returns how the lowercase query text matches the name or
+// aliases of an entity. For exact alias matches the alias is returned.
+func matchNames(low, name string, aliases []string) (nameMatch, string) {
+	if strings.ToLower(name) == low {
+		return exactNameMatch, ""
+	}
+	for _, a := range aliases {
+		if strings.ToLower(a) == low {
+			return exactAliasMatch, a
+		}
+	}
+	return partialMatch, ""
+}
+
+// nameSimilarity returns how well the lowercase query text matches the
+// name or aliases of an entity, from 0 to 1. If an alias matches better
+// than the name, it is also returned.
+func nameSimilarity(low, name string, aliases []string, m Matcher) (float64, string) {
+	kind, alias := matchNames(low, name, aliases)
+	switch kind {
+	case exactNameMatch:
+		return 1.0, ""
+	case exactAliasMatch:
+		return exactAliasSimilarity, alias
+	}
+	best := partialSimilarity(low, name, m)
+	for _, a := range aliases {
+		if as := partialSimilarity(low, a, m) * aliasWeight; as > best {
+			best, alias = as, a
+		}
+	}
+	return best, alias
+}
+
+// partialSimilarity scores a partial match of the lowercase query text
+// to a name, by recall of the name tokens or fuzzy similarity.
+func partialSimilarity(low, name string, m Matcher) float64 {
+	sim := 0.0
+	if hasTokenPrefixes(name, low) {
+		// essentially recall since there's no mismatch to low
+		sim = float64(len(low)) / float64(len(name))
+		if sim > 1.0 {
+			sim = 1.0
+		}
+	}
+	if m != nil {
+		if fuzzy := m.Similarity(low, strings.ToLower(name)); fuzzy > sim {
+			sim = fuzzy
+		}
+	}
+	return sim
+}
+
+// aliasValues returns the aliases in an entity's "aliases" property,
+// which may be a single string or a list of strings.
+func aliasValues(v interface{}) ([]string, bool) {
+	var res []string
+	for _, x := range PropertyValues(v) {
+		s, ok := x.(string)
+		if !ok {
+			return nil, false
+		}
+		res = append(res, s)
+	}
+	return res, true
+}
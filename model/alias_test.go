@@ -0,0 +1,116 @@
+package model
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMatchNames(t *testing.T) {
+	aliases := []string{"BRCAI", "IRIS"}
+	tests := []struct {
+		low     string
+		name    string
+		aliases []string
+		want    nameMatch
+		alias   string
+	}{
+		{"brca1", "BRCA1", aliases, exactNameMatch, ""},
+		{"iris", "BRCA1", aliases, exactAliasMatch, "IRIS"},
+		// the name is preferred to an alias with the same text
+		{"brca1", "BRCA1", []string{"brca1"}, exactNameMatch, ""},
+		{"brca", "BRCA1", aliases, partialMatch, ""},
+		{"iris", "BRCA1", nil, partialMatch, ""},
+		{"", "BRCA1", aliases, partialMatch, ""},
+		{"", "BRCA1", []string{""}, exactAliasMatch, ""},
+	}
+	for _, tc := range tests {
+		got, alias := matchNames(tc.low, tc.name, tc.aliases)
+		if got != tc.want || alias != tc.alias {
+			t.Errorf("matchNames(%q, %q, %v) = %v, %q, want %v, %q",
+				tc.low, tc.name, tc.aliases, got, alias, tc.want, tc.alias)
+		}
+	}
+}
+
+func TestNameSimilarity(t *testing.T) {
+	tests := []struct {
+		low     string
+		name    string
+		aliases []string
+		m       Matcher
+		want    float64
+		alias   string
+	}{
+		{"brca1", "BRCA1", []string{"IRIS"}, nil, 1, ""},
+		{"iris", "BRCA1", []string{"BRCAI", "IRIS"}, nil, exactAliasSimilarity, "IRIS"},
+		{"brca", "BRCA1", nil, nil, 0.8, ""},
+		// partial alias matches are weighted below the name
+		{"brc", "XYZ", []string{"BRCC1"}, nil, 0.6 * aliasWeight, "BRCC1"},
+		{"tp", "TP53", []string{"TPX"}, nil, 2.0 / 3 * aliasWeight, "TPX"},
+		{"brca", "BRCA1", []string{"BRCA"}, nil, exactAliasSimilarity, "BRCA"},
+		{"brca2", "BRCA1", nil, nil, 0, ""},
+		{"brca2", "BRCA1", nil, Levenshtein{}, 0.8, ""},
+		{"brca2", "XYZ", []string{"BRCA1"}, Levenshtein{}, 0.8 * aliasWeight, "BRCA1"},
+		{"", "BRCA1", []string{"IRIS"}, nil, 0, ""},
+	}
+	for _, tc := range tests {
+		got, alias := nameSimilarity(tc.low, tc.name, tc.aliases, tc.m)
+		if math.Abs(got-tc.want) > 0.00005 || alias != tc.alias {
+			t.Errorf("nameSimilarity(%q, %q, %v) = %.4f, %q, want %.4f, %q",
+				tc.low, tc.name, tc.aliases, got, alias, tc.want, tc.alias)
+		}
+	}
+}
+
+func TestAliasValues(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want []string
+		ok   bool
+	}{
+		{nil, nil, true},
+		{"IRIS", []string{"IRIS"}, true},
+		{[]interface{}{"BRCAI", "IRIS"}, []string{"BRCAI", "IRIS"}, true},
+		{[]string{"BRCAI", "IRIS"}, []string{"BRCAI", "IRIS"}, true},
+		{[]interface{}{}, nil, true},
+		{[]interface{}{"IRIS", 1.0}, nil, false},
+		{1.0, nil, false},
+	}
+	for _, tc := range tests {
+		got, ok := aliasValues(tc.v)
+		if ok != tc.ok || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("aliasValues(%v) = %v, %v, want %v, %v", tc.v, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestSourceQueryAlias(t *testing.T) {
+	tests := []struct {
+		text  string
+		top   EntityID
+		alias string
+	}{
+		{"IRIS", "gene:672", "IRIS"},
+		{"iris", "gene:672", "IRIS"},
+		{"p53", "gene:7157", "P53"},
+		// names are not reported as aliases
+		{"TP53", "gene:7157", ""},
+	}
+	testSources(t, func(t *testing.T, src Source) {
+		for _, tc := range tests {
+			res, err := src.Query(&QueryRequest{Text: tc.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Results) == 0 {
+				t.Errorf("Query(%q): no results, want %s", tc.text, tc.top)
+				continue
+			}
+			if c := res.Results[0]; c.ID != tc.top || c.MatchedAlias != tc.alias {
+				t.Errorf("Query(%q) = %s (alias %q), want %s (alias %q)",
+					tc.text, c.ID, c.MatchedAlias, tc.top, tc.alias)
+			}
+		}
+	})
+}
@@ -0,0 +1,55 @@
+package model
+
+import (
+	"context"
+)
+
+// WithContext returns a ContextSource for the data source. Sources that
+// already implement ContextSource are returned as-is, other sources are
+// adapted so that a cancelled context returns its error, although the
+// underlying lookup itself cannot be interrupted.
+func WithContext(src Source) ContextSource {
+	if cs, ok := src.(ContextSource); ok {
+		return cs
+	}
+	return sourceAdapter{src}
+}
+
+// sourceAdapter implements ContextSource for a Source.
+type sourceAdapter struct {
+	Source
+}
+
+// GetEntityContext returns the Entity matching the provided ID,
+// or ErrNotFound if there is none.
+func (a sourceAdapter) GetEntityContext(ctx context.Context, entityID EntityID) (*Entity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e, ok := a.GetEntity(entityID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+// QueryContext queries entitities for a match.
+func (a sourceAdapter) QueryContext(ctx context.Context, q *QueryRequest) (*QueryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res, err := a.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	return res, ctx.Err()
+}
+
+// QueryPrefixContext searches entitities for a prefix match.
+func (a sourceAdapter) QueryPrefixContext(ctx context.Context, text string, limit int) ([]*Entity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	res := a.QueryPrefix(text, limit)
+	return res, ctx.Err()
+}
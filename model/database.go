@@ -7,10 +7,15 @@
 package model
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
+	"io"
 	"log"
 	"strings"
+	"sync"
+
+	// postgres database driver
+	_ "github.com/lib/pq"
 
 	// note: must build with "fts5" build tag!
 	// e.g. go build --tags "fts5" .
@@ -35,10 +40,28 @@ type DatabaseSource struct {
 
 	// cache maps from Entity Type ID to a Property list for all supported entity types.
 	properties map[string][]*Property
+
+	// matcher finds and scores fuzzy name matches (nil to disable).
+	matcher Matcher
+
+	// hasHistory is true if the database maps retired IDs to their replacements.
+	hasHistory bool
+
+	// hasAliases is true if the database has entity aliases.
+	hasAliases bool
+
+	// vocab lists the terms of the full-text index, and vocabTrigrams maps
+	// trigrams to the terms containing them, to find fuzzy match candidates
+	// like a MemorySource. Both are loaded on the first fuzzy search.
+	vocabOnce     sync.Once
+	vocab         []string
+	vocabTrigrams map[string][]int
 }
 
-// ensure it implements the interface
+// ensure it implements the interfaces
 var _ Source = &DatabaseSource{}
+var _ ContextSource = &DatabaseSource{}
+var _ io.Closer = &DatabaseSource{}
 
 // Name of the data Source.
 func (s *DatabaseSource) Name() string {
@@ -75,21 +98,65 @@ func (s *DatabaseSource) Properties(typeID string) []*Property {
 
 // GetEntity returns the Entity matching the provided ID.
 func (s *DatabaseSource) GetEntity(entityID EntityID) (*Entity, bool) {
+	e, err := s.GetEntityContext(context.Background(), entityID)
+	if err != nil {
+		if err != ErrNotFound {
+			log.Println(err)
+		}
+		return nil, false
+	}
+	return e, true
+}
+
+// GetEntityContext returns the Entity matching the provided ID,
+// or ErrNotFound if there is none. Retired IDs resolve to the
+// current Entity that replaced them.
+func (s *DatabaseSource) GetEntityContext(ctx context.Context, entityID EntityID) (*Entity, error) {
 	// FIXME: use Type in the query too
-	ents, _ := s.getExactIDMatches(entityID.ID())
+	ents, err := s.getExactIDMatches(ctx, entityID.ID())
+	if err != nil {
+		return nil, err
+	}
+	e := findEntityType(ents, entityID.Type())
+	if e == nil {
+		ents, err = s.getRetiredIDMatches(ctx, entityID.ID())
+		if err != nil {
+			return nil, err
+		}
+		e = findEntityType(ents, entityID.Type())
+		if e == nil {
+			return nil, ErrNotFound
+		}
+	}
+	e.Properties, err = s.getEntityProps(ctx, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	e.Aliases, err = s.getEntityAliases(ctx, e.ID)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// findEntityType returns the first Entity with the Type ID given, either
+// in its ID or in any of its Types.
+func findEntityType(ents []*Entity, typeID string) *Entity {
 	for _, e := range ents {
+		if e.ID.Type() == typeID {
+			return e
+		}
 		for _, t := range e.Types {
-			if t.ID == entityID.Type() {
-				e.Properties, _ = s.getEntityProps(entityID)
-				return e, true
+			if t != nil && t.ID == typeID {
+				return e
 			}
 		}
 	}
-	return nil, false
+	return nil
 }
 
-func (s *DatabaseSource) getEntityProps(eid EntityID) (map[string]interface{}, error) {
-	rows, err := s.doQuery("entity_property_values", eid.Type(), eid.ID())
+func (s *DatabaseSource) getEntityProps(ctx context.Context, eid EntityID) (map[string]interface{}, error) {
+	rows, err := s.doQuery(ctx, "entity_property_values", eid.Type(), eid.ID())
 	if err != nil {
 		return nil, err
 	}
@@ -102,26 +169,73 @@ func (s *DatabaseSource) getEntityProps(eid EntityID) (map[string]interface{}, e
 			rows.Close()
 			return nil, err
 		}
-		res[propName] = propValue
+		// collect multiple values for the same property into a list
+		switch prev := res[propName].(type) {
+		case nil:
+			res[propName] = propValue
+		case []interface{}:
+			res[propName] = append(prev, propValue)
+		default:
+			res[propName] = []interface{}{prev, propValue}
+		}
+	}
+	return res, rows.Close()
+}
+
+// getEntityAliases returns the aliases of an entity.
+func (s *DatabaseSource) getEntityAliases(ctx context.Context, eid EntityID) ([]string, error) {
+	if !s.hasAliases {
+		return nil, nil
+	}
+	rows, err := s.doQuery(ctx, "entity_aliases", eid.Type(), eid.ID())
+	if err != nil {
+		return nil, err
+	}
+	var res []string
+	for rows.Next() {
+		aliases := ""
+		if err = rows.Scan(&aliases); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		for _, a := range strings.Split(aliases, "\n") {
+			if a != "" {
+				res = append(res, a)
+			}
+		}
 	}
 	return res, rows.Close()
 }
 
-func (s *DatabaseSource) getExactIDMatches(id string) ([]*Entity, bool) {
+// candidateFeatures computes the features of a candidate for a query.
+// It returns false if the candidate should be dropped.
+func (s *DatabaseSource) candidateFeatures(ctx context.Context, q *QueryRequest, c *Candidate) (*matchFeatures, bool, error) {
+	aliases, err := s.getEntityAliases(ctx, c.ID)
+	if err != nil {
+		return nil, false, err
+	}
+	f := newFeatures(q, c.ID, c.Name, aliases, s.matcher)
+	return f, f.scoreTypes(q, c.Types), nil
+}
+
+func (s *DatabaseSource) getExactIDMatches(ctx context.Context, id string) ([]*Entity, error) {
 	eTypes := ""
-	rows, err := s.doQuery("entity_by_id", id)
+	rows, err := s.doQuery(ctx, "entity_by_id", id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, false
+			return nil, nil
 		}
-		log.Println(err)
-		return nil, false
+		return nil, err
 	}
 
 	var res []*Entity
 	for rows.Next() {
 		e := &Entity{}
 		err = rows.Scan(&e.ID, &e.Name, &e.Description, &eTypes)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
 		for i, tid := range strings.Split(eTypes, ",") {
 			if i == 0 {
 				e.ID = EntityID(tid + ":" + string(e.ID))
@@ -130,13 +244,69 @@ func (s *DatabaseSource) getExactIDMatches(id string) ([]*Entity, bool) {
 		}
 		res = append(res, e)
 	}
-	rows.Close()
+	return res, rows.Close()
+}
+
+// getRetiredIDMatches returns the current entities that replaced a
+// retired ID, following chains of replacements.
+func (s *DatabaseSource) getRetiredIDMatches(ctx context.Context, id string) ([]*Entity, error) {
+	if !s.hasHistory {
+		return nil, nil
+	}
+	replaced, err := s.getReplacedBy(ctx, id)
+	if err != nil {
+		return nil, err
+	}
 
-	return res, len(res) > 0
+	var res []*Entity
+	for typeID, newID := range replaced {
+		for i := 0; i < maxRedirects; i++ {
+			ents, err := s.getExactIDMatches(ctx, newID)
+			if err != nil {
+				return nil, err
+			}
+			if e := findEntityType(ents, typeID); e != nil {
+				res = append(res, e)
+				break
+			}
+			next, err := s.getReplacedBy(ctx, newID)
+			if err != nil {
+				return nil, err
+			}
+			if newID = next[typeID]; newID == "" {
+				break
+			}
+		}
+	}
+	return res, nil
+}
+
+// getReplacedBy returns a map from Type ID to the raw ID that replaced
+// the retired raw ID given.
+func (s *DatabaseSource) getReplacedBy(ctx context.Context, id string) (map[string]string, error) {
+	rows, err := s.doQuery(ctx, "entity_replaced_by", id)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]string)
+	for rows.Next() {
+		typeID, newID := "", ""
+		if err = rows.Scan(&typeID, &newID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		res[typeID] = newID
+	}
+	return res, rows.Close()
 }
 
 // Query entitities for a match.
 func (s *DatabaseSource) Query(q *QueryRequest) (*QueryResponse, error) {
+	return s.QueryContext(context.Background(), q)
+}
+
+// QueryContext queries entitities for a match.
+func (s *DatabaseSource) QueryContext(ctx context.Context, q *QueryRequest) (*QueryResponse, error) {
 	if q.Limit == 0 {
 		q.Limit = 25
 	}
@@ -146,28 +316,43 @@ func (s *DatabaseSource) Query(q *QueryRequest) (*QueryResponse, error) {
 	}
 	log.Println(q)
 
+	trace := traceFrom(ctx)
+
 	// fast-track exact ID matches
-	if ents, ok := s.getExactIDMatches(q.Text); ok {
+	ents, err := s.getExactIDMatches(ctx, q.Text)
+	if err != nil {
+		return nil, err
+	}
+	if len(ents) > 0 {
 		log.Println("one-shot:", ents)
-		for _, e := range ents {
-			res.Results = append(res.Results, &Candidate{
-				ID:    e.ID,
-				Name:  e.Name,
-				Types: e.Types,
-				Score: 100.0,
-				Match: true,
-			})
+		trace.Step("exact_id", s.queryText("entity_by_id"), q.Text)
+		res.Results = exactCandidates(ctx, q, ents, "")
+		if len(res.Results) > 0 {
+			return res, nil
+		}
+	}
+	// then retired IDs, which redirect to the entities that replaced them
+	ents, err = s.getRetiredIDMatches(ctx, q.Text)
+	if err != nil {
+		return nil, err
+	}
+	if len(ents) > 0 {
+		log.Println("redirect:", ents)
+		trace.Step("retired_id", s.queryText("entity_replaced_by"), q.Text)
+		res.Results = exactCandidates(ctx, q, ents, q.Text)
+		if len(res.Results) > 0 {
+			return res, nil
 		}
-		return res, nil
 	}
 
-	var rows *sql.Rows
-	var err error
+	// when scoring properties, consider more candidates than requested
+	// since the property evidence can change the ranking
+	maxCandidates := q.Limit
 	if len(q.Properties) > 0 {
-		rows, err = s.doQuery("entity_search_by_props", q.Text, q.Properties)
-	} else {
-		rows, err = s.doQuery("entity_search", q.Text)
+		maxCandidates *= 4
 	}
+
+	rows, err := s.doQuery(ctx, "entity_search", q.Text)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return res, nil
@@ -175,66 +360,245 @@ func (s *DatabaseSource) Query(q *QueryRequest) (*QueryResponse, error) {
 
 		return nil, err
 	}
-	scoreScale := 0.0
+	// the full-text rank is only used to choose the candidates, since it
+	// is not comparable across queries. candidates are scored by features.
+	trace.Step("entity_search", s.queryText("entity_search"), q.Text)
+	features := make(map[*Candidate]*matchFeatures, maxCandidates)
 	for rows.Next() {
-		c := &Candidate{}
-		cTypes := ""
-		err = rows.Scan(&c.ID, &c.Name, &cTypes, &c.Score)
+		c, err := s.scanCandidate(rows)
 		if err != nil {
 			rows.Close()
 			return nil, err
 		}
-		hitType := (q.Type == "")
-		for i, tid := range strings.Split(cTypes, ",") {
-			if i == 0 {
-				c.ID = EntityID(tid + ":" + string(c.ID))
-			}
-			c.Types = append(c.Types, s.types[tid])
-			if tid == q.Type {
-				hitType = true
-			}
+		rank := c.Score
+		trace.Consider(c, &rank)
+		f, keep, err := s.candidateFeatures(ctx, q, c)
+		if err != nil {
+			rows.Close()
+			return nil, err
 		}
-		if !hitType {
+		if !keep {
+			f.Apply(q, c)
+			trace.Drop(c, typeMismatchReason(q))
 			continue
 		}
-		if scoreScale == 0.0 {
-			s1 := float64(len(q.Text)) / float64(len(c.ID))
-			s2 := float64(len(q.Text)) / float64(len(c.Name))
-			if s2 > s1 {
-				s1 = s2
-			}
-			scoreScale = (s1 * 100.0) / c.Score
-		}
-		c.Score = c.Score * scoreScale
-
-		c.Match = c.Score > 80.0
+		features[c] = f
 		res.Results = append(res.Results, c)
-		if len(res.Results) == q.Limit {
+		if len(res.Results) == maxCandidates {
 			break
 		}
 	}
 	rows.Close()
 
+	// similar terms, only if the names found are not good hits already
+	// (before the property evidence, which is scored below)
+	if s.matcher != nil && len(res.Results) < maxCandidates && !hasGoodNameHit(q, features) {
+		fuzzy, err := s.fuzzySearch(ctx, q, maxCandidates-len(res.Results), features)
+		if err != nil {
+			return nil, err
+		}
+		res.Results = append(res.Results, fuzzy...)
+	}
+
+	scored := res.Results[:0]
+	for _, c := range res.Results {
+		f := features[c]
+		if len(q.Properties) > 0 {
+			// use the property values as evidence for or against each candidate
+			props, err := s.getEntityProps(ctx, c.ID)
+			if err != nil {
+				return nil, err
+			}
+			f.scoreProperties(props, q.Properties)
+		}
+		f.Apply(q, c)
+		if c.Score <= 0.0 {
+			trace.Drop(c, "score is 0")
+			continue
+		}
+		scored = append(scored, c)
+	}
+	res.Results = rankCandidates(scored, q.Limit)
 	return res, nil
 }
 
+// scanCandidate reads a Candidate from an entity search result row.
+func (s *DatabaseSource) scanCandidate(rows *sql.Rows) (*Candidate, error) {
+	c := &Candidate{}
+	cTypes := ""
+	err := rows.Scan(&c.ID, &c.Name, &cTypes, &c.Score)
+	if err != nil {
+		return nil, err
+	}
+	for i, tid := range strings.Split(cTypes, ",") {
+		if i == 0 {
+			c.ID = EntityID(tid + ":" + string(c.ID))
+		}
+		c.Types = append(c.Types, s.types[tid])
+	}
+	return c, nil
+}
+
+// hasGoodNameHit returns true if any candidate scores at least
+// matchThreshold from its name and types alone.
+func hasGoodNameHit(q *QueryRequest, features map[*Candidate]*matchFeatures) bool {
+	for _, f := range features {
+		if f.Score(q) >= matchThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzySearch finds up to limit candidates using terms from the full-text
+// index that are similar to the query tokens. As in a MemorySource, only
+// terms that share a trigram with a query token and are in its length
+// range are compared. The features of the new candidates are added to have.
+func (s *DatabaseSource) fuzzySearch(ctx context.Context, q *QueryRequest, limit int, have map[*Candidate]*matchFeatures) ([]*Candidate, error) {
+	if _, ok := _queries[s.driverName]["entity_search_terms"]; !ok {
+		return nil, nil
+	}
+	s.vocabOnce.Do(s.loadVocab)
+	if s.vocabTrigrams == nil {
+		return nil, nil
+	}
+	var groups [][]string
+	for _, tok := range tokenize(q.Text) {
+		var vocab []string
+		for _, i := range trigramCandidates(s.matcher, tok, s.vocab, s.vocabTrigrams) {
+			vocab = append(vocab, s.vocab[i])
+		}
+		terms := bestFuzzyTerms(s.matcher, tok, vocab, 10)
+		if len(terms) > 0 {
+			groups = append(groups, terms)
+		}
+	}
+	if len(groups) == 0 {
+		return nil, nil
+	}
+
+	expr := s.termsExpr(groups)
+	rows, err := s.doQuery(ctx, "entity_search_terms", expr)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	trace := traceFrom(ctx)
+	trace.Step("entity_search_terms", s.queryText("entity_search_terms"), expr)
+	seen := make(map[EntityID]struct{}, len(have))
+	for c := range have {
+		seen[c.ID] = struct{}{}
+	}
+	var res []*Candidate
+	for rows.Next() && len(res) < limit {
+		c, err := s.scanCandidate(rows)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := seen[c.ID]; ok {
+			continue
+		}
+		seen[c.ID] = struct{}{}
+		rank := c.Score
+		trace.Consider(c, &rank)
+		f, keep, err := s.candidateFeatures(ctx, q, c)
+		if err != nil {
+			return nil, err
+		}
+		if !keep {
+			f.Apply(q, c)
+			trace.Drop(c, typeMismatchReason(q))
+			continue
+		}
+		have[c] = f
+		res = append(res, c)
+	}
+	return res, nil
+}
+
+// loadVocab loads the terms of the full-text index and their trigrams.
+// The vocabulary is left empty if the database doesn't have one.
+func (s *DatabaseSource) loadVocab() {
+	// not the query's context, so that a timeout doesn't disable fuzzy search
+	rows, err := s.doQuery(context.Background(), "entity_vocab")
+	if err != nil {
+		// older databases may not have the vocabulary table
+		log.Println("fuzzy search unavailable:", err)
+		return
+	}
+	defer rows.Close()
+	var vocab []string
+	for rows.Next() {
+		term := ""
+		if err = rows.Scan(&term); err != nil {
+			log.Println("fuzzy search unavailable:", err)
+			return
+		}
+		vocab = append(vocab, term)
+	}
+	if err = rows.Err(); err != nil {
+		log.Println("fuzzy search unavailable:", err)
+		return
+	}
+	s.vocab = vocab
+	s.vocabTrigrams = newTermTrigrams(vocab)
+}
+
+// termsExpr returns a full-text query expression that matches any of the
+// terms in each group, and every one of the groups.
+func (s *DatabaseSource) termsExpr(groups [][]string) string {
+	or, and, quote := " OR ", " AND ", `"`
+	if s.driverName == "postgres" {
+		or, and, quote = " | ", " & ", "'"
+	}
+	clauses := make([]string, len(groups))
+	for i, terms := range groups {
+		quoted := make([]string, len(terms))
+		for j, t := range terms {
+			if s.driverName == "postgres" {
+				t = strings.Replace(t, `\`, `\\`, -1)
+			}
+			quoted[j] = quote + strings.Replace(t, quote, quote+quote, -1) + quote
+		}
+		clauses[i] = "(" + strings.Join(quoted, or) + ")"
+	}
+	return strings.Join(clauses, and)
+}
+
 // QueryPrefix searches entitities for a prefix match.
 func (s *DatabaseSource) QueryPrefix(text string, limit int) []*Entity {
+	result, err := s.QueryPrefixContext(context.Background(), text, limit)
+	if err != nil {
+		log.Println(err)
+	}
+	return result
+}
+
+// QueryPrefixContext searches entitities for a prefix match.
+func (s *DatabaseSource) QueryPrefixContext(ctx context.Context, text string, limit int) ([]*Entity, error) {
 	log.Println("prefix: ", text, limit)
 	// fast-track exact ID matches
-	if ents, ok := s.getExactIDMatches(text); ok {
+	ents, err := s.getExactIDMatches(ctx, text)
+	if err != nil {
+		return nil, err
+	}
+	if len(ents) > 0 {
 		log.Println("prefix one-shot:", ents)
-		return ents
+		return ents, nil
 	}
 
 	var result []*Entity
 
-	rows, err := s.doQuery("entity_by_prefix", text)
+	rows, err := s.doQuery(ctx, "entity_by_prefix", text)
 	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Println(err)
+		if err == sql.ErrNoRows {
+			return result, nil
 		}
-		return result
+		return nil, err
 	}
 	for rows.Next() {
 		e := &Entity{}
@@ -242,8 +606,7 @@ func (s *DatabaseSource) QueryPrefix(text string, limit int) []*Entity {
 		err = rows.Scan(&e.ID, &e.Name, &e.Description, &eTypes)
 		if err != nil {
 			rows.Close()
-			log.Println(err)
-			return nil
+			return nil, err
 		}
 
 		for i, tid := range strings.Split(eTypes, ",") {
@@ -257,9 +620,7 @@ func (s *DatabaseSource) QueryPrefix(text string, limit int) []*Entity {
 			break
 		}
 	}
-	rows.Close()
-
-	return result
+	return result, rows.Close()
 }
 
 // ViewURL returns the template for a View URL.
@@ -267,6 +628,12 @@ func (s *DatabaseSource) ViewURL() string {
 	return s.viewURL
 }
 
+// SetMatcher sets the fuzzy matching algorithm used to find and score
+// candidates. A nil Matcher disables fuzzy matching.
+func (s *DatabaseSource) SetMatcher(m Matcher) {
+	s.matcher = m
+}
+
 ////////////////
 
 var _queries = map[string]map[string]string{
@@ -282,6 +649,10 @@ var _queries = map[string]map[string]string{
 		// only first two are required, description must be non-null but blank is ok
 		"properties": "SELECT prop_id, prop_name, COALESCE(prop_description,'') FROM recongo_properties",
 
+		// list all property types (id, name, description, value type)
+		// same as above, but value type must be non-null but blank is ok
+		"properties_typed": "SELECT prop_id, prop_name, COALESCE(prop_description,''), COALESCE(prop_type,'') FROM recongo_properties",
+
 		// list all pairs of entity id-property id combinations
 		"properties_by_type": "SELECT prop_id, type_id FROM recongo_props2types",
 	},
@@ -299,54 +670,84 @@ var _queries = map[string]map[string]string{
 		"entity_search": `SELECT ent_id, ent_name, ent_types, bm25(recongo_entities_fts) as score
 			FROM recongo_entities_fts WHERE recongo_entities_fts MATCH ?1||'*'
 			ORDER BY score`,
-		// entity_search_by_props adds additional joins for property filters
+
+		// full-text search entities for a query expression of terms
+		"entity_search_terms": `SELECT ent_id, ent_name, ent_types, bm25(recongo_entities_fts) as score
+			FROM recongo_entities_fts WHERE recongo_entities_fts MATCH ?1
+			ORDER BY score`,
+
+		// list all the full-text index terms (for fuzzy matching)
+		"entity_vocab": `SELECT term FROM recongo_entities_vocab`,
 
 		// find all properties and values for a entity id
+		// (properties are stored under the first type_id, as used in entity ids)
 		"entity_property_values": `SELECT prop_id, prop_value FROM recongo_entity_properties
 			WHERE ent_types=?1 AND ent_id=?2 ORDER BY prop_id, prop_value`,
+
+		// find the entity ids that replaced a retired entity id
+		"entity_replaced_by": `SELECT type_id, new_id FROM recongo_replaced WHERE old_id=?1`,
+
+		// find the newline-separated aliases for a entity id
+		// (entity ids use the first of the comma-separated type_ids)
+		"entity_aliases": `SELECT COALESCE(ent_aliases,'') FROM recongo_entities
+			WHERE ent_id=?2 AND (ent_types=?1 OR ent_types LIKE ?1||',%')`,
+	},
+	// note: requires the pg_trgm extension
+	"postgres": map[string]string{
+		// find an entity with a specific id
+		"entity_by_id": `SELECT ent_id, ent_name, COALESCE(ent_description,''), ent_types FROM recongo_entities
+			WHERE ent_id=$1`,
+
+		// find entities with a specific prefix
+		"entity_by_prefix": `SELECT ent_id, ent_name, COALESCE(ent_description,''), ent_types FROM recongo_entities
+			WHERE (ent_id LIKE $1||'%' OR ent_name ILIKE $1||'%')
+			ORDER BY ent_name, ent_id`,
+
+		// full-text and trigram search entities for a text query
+		// scores are negated so that the best matches sort first (like bm25)
+		"entity_search": `SELECT ent_id, ent_name, ent_types,
+				-(ts_rank(ent_tsv, plainto_tsquery('simple', $1)) + similarity(ent_name, $1)) AS score
+			FROM recongo_entities
+			WHERE ent_tsv @@ plainto_tsquery('simple', $1) OR ent_name % $1 OR ent_name ILIKE $1||'%'
+			ORDER BY score`,
+
+		// full-text search entities for a query expression of terms
+		"entity_search_terms": `SELECT ent_id, ent_name, ent_types,
+				-ts_rank(ent_tsv, to_tsquery('simple', $1)) AS score
+			FROM recongo_entities WHERE ent_tsv @@ to_tsquery('simple', $1)
+			ORDER BY score`,
+
+		// list all the full-text index terms (for fuzzy matching)
+		"entity_vocab": `SELECT word FROM ts_stat('SELECT ent_tsv FROM recongo_entities')`,
+
+		// find all properties and values for a entity id
+		// (properties are stored under the first type_id, as used in entity ids)
+		"entity_property_values": `SELECT prop_id, prop_value FROM recongo_entity_properties
+			WHERE ent_types=$1 AND ent_id=$2 ORDER BY prop_id, prop_value`,
+
+		// find the entity ids that replaced a retired entity id
+		"entity_replaced_by": `SELECT type_id, new_id FROM recongo_replaced WHERE old_id=$1`,
+
+		// find the newline-separated aliases for a entity id
+		// (entity ids use the first of the comma-separated type_ids)
+		"entity_aliases": `SELECT COALESCE(ent_aliases,'') FROM recongo_entities
+			WHERE ent_id=$2 AND (ent_types=$1 OR ent_types LIKE $1||',%')`,
 	},
 }
 
-func (s *DatabaseSource) doQuery(qname string, args ...interface{}) (*sql.Rows, error) {
+// queryText returns the SQL for a named query for the database driver.
+func (s *DatabaseSource) queryText(qname string) string {
 	query, ok := _queries[s.driverName][qname]
 	if !ok {
 		query = _queries["all"][qname]
 	}
-	// special case, do a entity_search but also filter by property values
-	if qname == "entity_search_by_props" {
-		if s.driverName == "sqlite3" {
-			q1 := `SELECT a.ent_id, a.ent_name, a.ent_types, bm25(recongo_entities_fts) as score
-			FROM recongo_entities_fts a `
-			q2 := `WHERE recongo_entities_fts MATCH ? `
-			q3 := `ORDER BY score`
-
-			props, ok := args[1].([]*QueryProperty)
-			if !ok {
-				log.Printf("%T", args[1])
-				return nil, fmt.Errorf("invalid property set")
-			}
-			newargs := make([]interface{}, 1, len(props)+1)
-			newargs[0] = args[0]
-			for i, pd := range props {
-				ta := string([]rune{'b' + rune(i)})
-				q1 += ", recongo_entity_properties " + ta + " "
-				q2 += "  AND a.ent_id=" + ta + ".ent_id AND " + ta + ".prop_id=? AND " + ta + ".prop_value=? "
-				switch px := pd.Value.(type) {
-				case string:
-					newargs = append(newargs, pd.ID, px)
-				case map[string]interface{}:
-					eid := EntityID(px["id"].(string))
-					newargs = append(newargs, pd.ID, eid.ID())
-				default:
-					newargs = append(newargs, pd.ID, pd.Value)
-				}
-			}
-			query = q1 + q2 + q3
-			args = newargs
-		}
-	}
+	return query
+}
+
+func (s *DatabaseSource) doQuery(ctx context.Context, qname string, args ...interface{}) (*sql.Rows, error) {
+	query := s.queryText(qname)
 	//log.Println(query, args)
-	return s.db.Query(query, args...)
+	return s.db.QueryContext(ctx, query, args...)
 }
 
 func dbOpen(driverName, connstring string) (Source, error) {
@@ -357,6 +758,7 @@ func dbOpen(driverName, connstring string) (Source, error) {
 	}
 	err = db.Ping()
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
@@ -366,86 +768,129 @@ func dbOpen(driverName, connstring string) (Source, error) {
 		types:      make(map[string]*Type),
 		properties: make(map[string][]*Property),
 	}
+	err = d.loadSchema(context.Background())
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return d, nil
+}
 
+// loadSchema loads the metadata, entity types and properties of the
+// database, and probes for the optional tables of newer databases.
+func (s *DatabaseSource) loadSchema(ctx context.Context) error {
 	/////////
 	// load metadata first
-	rows, err := d.doQuery("metadata")
+	rows, err := s.doQuery(ctx, "metadata")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	for rows.Next() {
 		key, val := "", ""
 		err = rows.Scan(&key, &val)
 		if err != nil {
 			rows.Close()
-			return nil, err
+			return err
 		}
 		switch key {
 		case "name":
-			d.name = val
+			s.name = val
 		case "identifierNamespace":
-			d.identifierNamespace = val
+			s.identifierNamespace = val
 		case "schemaNamespace":
-			d.schemaNamespace = val
+			s.schemaNamespace = val
 		case "view_url":
-			d.viewURL = val
+			s.viewURL = val
+		case "matcher":
+			s.matcher, err = GetMatcher(val)
+			if err != nil {
+				rows.Close()
+				return err
+			}
 		}
 	}
 	rows.Close()
 
 	////////////
 	// load all the entity types
-	rows, err = d.doQuery("types")
+	rows, err = s.doQuery(ctx, "types")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	for rows.Next() {
 		t := &Type{}
 		err = rows.Scan(&t.ID, &t.Name, &t.Description, &t.ViewURL)
 		if err != nil {
 			rows.Close()
-			return nil, err
+			return err
 		}
-		d.types[t.ID] = t
+		s.types[t.ID] = t
 	}
 	rows.Close()
 
 	// load a mapping from propID to all entity types
 	pairMap := make(map[string][]string)
-	rows, err = d.doQuery("properties_by_type")
+	rows, err = s.doQuery(ctx, "properties_by_type")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	for rows.Next() {
 		propID, typeID := "", ""
 		err = rows.Scan(&propID, &typeID)
 		if err != nil {
 			rows.Close()
-			return nil, err
+			return err
 		}
 		pairMap[propID] = append(pairMap[propID], typeID)
 	}
 	rows.Close()
 
-	rows, err = d.doQuery("properties")
+	// older databases do not have property value types
+	hasValueTypes := true
+	rows, err = s.doQuery(ctx, "properties_typed")
 	if err != nil {
-		return nil, err
+		hasValueTypes = false
+		rows, err = s.doQuery(ctx, "properties")
+	}
+	if err != nil {
+		return err
 	}
 	for rows.Next() {
 		p := &Property{}
-		err = rows.Scan(&p.ID, &p.Name, &p.Description)
+		if hasValueTypes {
+			err = rows.Scan(&p.ID, &p.Name, &p.Description, &p.ValueType)
+		} else {
+			err = rows.Scan(&p.ID, &p.Name, &p.Description)
+		}
 		if err != nil {
 			rows.Close()
-			return nil, err
+			return err
 		}
 		// defined, but not used?
 		if ents, ok := pairMap[p.ID]; ok {
 			for _, eid := range ents {
-				d.properties[eid] = append(d.properties[eid], p)
+				s.properties[eid] = append(s.properties[eid], p)
 			}
 		}
 	}
 	rows.Close()
 
-	return d, nil
+	// older databases do not have an ID history or aliases
+	rows, err = s.doQuery(ctx, "entity_replaced_by", "")
+	if err == nil {
+		s.hasHistory = true
+		rows.Close()
+	}
+	rows, err = s.doQuery(ctx, "entity_aliases", "", "")
+	if err == nil {
+		s.hasAliases = true
+		rows.Close()
+	}
+
+	return nil
+}
+
+// Close closes the underlying database handle.
+func (s *DatabaseSource) Close() error {
+	return s.db.Close()
 }
@@ -0,0 +1,351 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testTable is a table of rows loaded into a test database.
+type testTable struct {
+	name string
+	cols []string
+	rows [][]string
+}
+
+// testDatabase is a small gene database in the tables of SQLiteSchema and
+// PostgresSchema, with two genes named BRCA1, an alias, a gene with two
+// types, and a retired ID.
+var testDatabase = []testTable{
+	{"recongo_metadata", []string{"meta_key", "meta_value"}, [][]string{
+		{"name", "Genes"},
+		{"identifierNamespace", "ncbi"},
+		{"schemaNamespace", "ncbi"},
+		{"view_url", "https://ncbi.nlm.nih.gov/gene/%s"},
+	}},
+	{"recongo_types", []string{"type_id", "type_name", "type_description", "type_url"}, [][]string{
+		{"gene", "Gene", "", "https://ncbi.nlm.nih.gov/gene/%s"},
+		{"protein-coding", "Protein Coding", "", "https://ncbi.nlm.nih.gov/gene/%s"},
+	}},
+	{"recongo_properties", []string{"prop_id", "prop_name", "prop_description", "prop_type"}, [][]string{
+		{"tax_id", "Tax Id", "", "int"},
+	}},
+	{"recongo_props2types", []string{"prop_id", "type_id"}, [][]string{
+		{"tax_id", "gene"},
+	}},
+	{"recongo_entities", []string{"ent_types", "ent_id", "ent_name", "ent_description", "ent_aliases"}, [][]string{
+		{"gene", "672", "BRCA1", "BRCA1 DNA repair associated", "BRCAI\nBRCC1\nIRIS"},
+		{"gene", "12189", "Brca1", "breast cancer 1, early onset", ""},
+		{"gene,protein-coding", "7157", "TP53", "tumor protein p53", "P53\nLFS1"},
+	}},
+	{"recongo_entity_properties", []string{"ent_types", "ent_id", "prop_id", "prop_value"}, [][]string{
+		{"gene", "672", "tax_id", "9606"},
+		{"gene", "12189", "tax_id", "10090"},
+		{"gene", "7157", "tax_id", "9606"},
+	}},
+	{"recongo_replaced", []string{"type_id", "old_id", "new_id"}, [][]string{
+		{"gene", "100", "672"},
+	}},
+}
+
+// loadTestDatabase creates the tables of the schema in the database,
+// loads the test rows into them, and then creates the indexes.
+func loadTestDatabase(t *testing.T, db *sql.DB, driverName string, schema, indexes []string) {
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			if strings.Contains(err.Error(), "no such module: fts5") {
+				t.Skip("sqlite was built without fts5")
+			}
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range testDatabase {
+		params := make([]string, len(tt.cols))
+		for i := range params {
+			params[i] = "?"
+			if driverName == "postgres" {
+				params[i] = fmt.Sprintf("$%d", i+1)
+			}
+		}
+		stmt := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", tt.name,
+			strings.Join(tt.cols, ", "), strings.Join(params, ", "))
+		for _, row := range tt.rows {
+			args := make([]interface{}, len(row))
+			for i, v := range row {
+				args[i] = v
+			}
+			if _, err := db.Exec(stmt, args...); err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+		}
+	}
+	for _, stmt := range indexes {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// openTestSQLite loads the test database into a temporary sqlite file,
+// and returns a DatabaseSource for it. The test is skipped if sqlite
+// was built without the fts5 extension.
+func openTestSQLite(t *testing.T) *DatabaseSource {
+	dir, err := ioutil.TempDir("", "recongo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fn := filepath.Join(dir, "genes.sqlite")
+
+	db, err := sql.Open("sqlite3", fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	loadTestDatabase(t, db, "sqlite3", SQLiteSchema, SQLiteIndexes)
+
+	src, err := Load(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.(*DatabaseSource).Close() })
+	return src.(*DatabaseSource)
+}
+
+// pgTestSchema is created and dropped by the postgres tests, so that they
+// do not touch existing recongo tables in the test database.
+const pgTestSchema = "recongo_test"
+
+// openTestPostgres loads the test database into the database named by
+// the RECONGO_PG_DSN environment variable (a postgres:// URL), and
+// returns a DatabaseSource for it. The test is skipped if it is unset.
+func openTestPostgres(t *testing.T) *DatabaseSource {
+	dsn := os.Getenv("RECONGO_PG_DSN")
+	if dsn == "" {
+		t.Skip("RECONGO_PG_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range []string{
+		"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+		"DROP SCHEMA IF EXISTS " + pgTestSchema + " CASCADE",
+		"CREATE SCHEMA " + pgTestSchema,
+	} {
+		if _, err = db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		db, err := sql.Open("postgres", dsn)
+		if err == nil {
+			db.Exec("DROP SCHEMA IF EXISTS " + pgTestSchema + " CASCADE")
+			db.Close()
+		}
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := u.Query()
+	params.Set("search_path", pgTestSchema+",public")
+	u.RawQuery = params.Encode()
+
+	tdb, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tdb.Close()
+	loadTestDatabase(t, tdb, "postgres", PostgresSchema, PostgresIndexes)
+
+	src, err := Load(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.(*DatabaseSource).Close() })
+	return src.(*DatabaseSource)
+}
+
+// testFlatFile has the same rows as testDatabase, as a flat file.
+var testFlatFile = []string{
+	"ncbi\tGenes\tncbi\t" + `[{"id":"gene","name":"Gene","url":"https://ncbi.nlm.nih.gov/gene/%s"},` +
+		`{"id":"protein-coding","name":"Protein Coding","url":"https://ncbi.nlm.nih.gov/gene/%s"}]` + "\n",
+	"tax_id\tTax Id\tproperty,gene\t" + `{"type":"int"}` + "\n",
+	"672\tBRCA1\tgene\t" + `{"description":"BRCA1 DNA repair associated","aliases":["BRCAI","BRCC1","IRIS"],"tax_id":"9606"}` + "\n",
+	"12189\tBrca1\tgene\t" + `{"description":"breast cancer 1, early onset","tax_id":"10090"}` + "\n",
+	"7157\tTP53\tgene,protein-coding\t" + `{"description":"tumor protein p53","aliases":["P53","LFS1"],"tax_id":"9606"}` + "\n",
+	"100\t672\treplaced_by,gene\t{}\n",
+}
+
+// testSources runs a test against the test data in every backend.
+func testSources(t *testing.T, f func(t *testing.T, src Source)) {
+	t.Run("memory", func(t *testing.T) {
+		src, err := Load(writeFlatFile(t, testFlatFile...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(t, src)
+	})
+	t.Run("sqlite3", func(t *testing.T) { f(t, openTestSQLite(t)) })
+	t.Run("postgres", func(t *testing.T) { f(t, openTestPostgres(t)) })
+}
+
+func TestSourceMetadata(t *testing.T) {
+	testSources(t, func(t *testing.T, src Source) {
+		if src.Name() != "Genes" || src.IdentifierNS() != "ncbi" {
+			t.Errorf("metadata = %q, %q, want Genes, ncbi", src.Name(), src.IdentifierNS())
+		}
+		if len(src.Types()) != 2 {
+			t.Errorf("types = %v, want gene and protein-coding", src.Types())
+		}
+		props := src.Properties("gene")
+		if len(props) != 1 || props[0].ID != "tax_id" || props[0].ValueType != "int" {
+			t.Errorf("properties = %v, want [tax_id (int)]", props)
+		}
+	})
+}
+
+func TestSourceGetEntity(t *testing.T) {
+	tests := []struct {
+		id      EntityID
+		want    EntityID
+		aliases []string
+		taxID   string
+	}{
+		{"gene:672", "gene:672", []string{"BRCAI", "BRCC1", "IRIS"}, "9606"},
+		{"gene:12189", "gene:12189", nil, "10090"},
+		// retired IDs resolve to their replacement
+		{"gene:100", "gene:672", []string{"BRCAI", "BRCC1", "IRIS"}, "9606"},
+		{"gene:1", "", nil, ""},
+		{"protein:672", "", nil, ""},
+		// entities are found under any of their types
+		{"gene:7157", "gene:7157", []string{"P53", "LFS1"}, "9606"},
+		{"protein-coding:7157", "gene:7157", []string{"P53", "LFS1"}, "9606"},
+		{"protein-coding:672", "", nil, ""},
+	}
+	testSources(t, func(t *testing.T, src Source) {
+		for _, tc := range tests {
+			e, ok := src.GetEntity(tc.id)
+			if tc.want == "" {
+				if ok {
+					t.Errorf("GetEntity(%s) = %s, want none", tc.id, e.ID)
+				}
+				continue
+			}
+			if !ok {
+				t.Errorf("GetEntity(%s) found nothing, want %s", tc.id, tc.want)
+				continue
+			}
+			if e.ID != tc.want || !reflect.DeepEqual(e.Aliases, tc.aliases) || e.Properties["tax_id"] != tc.taxID {
+				t.Errorf("GetEntity(%s) = %s %v %v, want %s %v tax_id=%s",
+					tc.id, e.ID, e.Aliases, e.Properties, tc.want, tc.aliases, tc.taxID)
+			}
+		}
+	})
+}
+
+func TestSourceQuery(t *testing.T) {
+	human := []*QueryProperty{{ID: "tax_id", Value: "9606"}}
+	tests := []struct {
+		name  string
+		q     *QueryRequest
+		top   EntityID
+		match bool
+	}{
+		{"exact id", &QueryRequest{Text: "7157"}, "gene:7157", true},
+		{"retired id", &QueryRequest{Text: "100"}, "gene:672", true},
+		{"name", &QueryRequest{Text: "TP53"}, "gene:7157", true},
+		{"alias", &QueryRequest{Text: "IRIS"}, "gene:672", true},
+		// two genes named BRCA1 are ambiguous without more evidence
+		{"ambiguous name", &QueryRequest{Text: "BRCA1"}, "", false},
+		{"name and property", &QueryRequest{Text: "BRCA1", Properties: human}, "gene:672", true},
+		{"wrong type", &QueryRequest{Text: "TP53", Type: TypeIDs{"protein"}}, "", false},
+	}
+	testSources(t, func(t *testing.T, src Source) {
+		for _, tc := range tests {
+			res, err := src.Query(tc.q)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if tc.top == "" {
+				for _, c := range res.Results {
+					if c.Match {
+						t.Errorf("%s: %s is a match, want none", tc.name, c.ID)
+					}
+				}
+				continue
+			}
+			if len(res.Results) == 0 {
+				t.Errorf("%s: no results, want %s", tc.name, tc.top)
+				continue
+			}
+			if c := res.Results[0]; c.ID != tc.top || c.Match != tc.match {
+				t.Errorf("%s: top = %s (match %v, score %v), want %s (match %v)",
+					tc.name, c.ID, c.Match, c.Score, tc.top, tc.match)
+			}
+		}
+	})
+}
+
+func TestSourceQueryPrefix(t *testing.T) {
+	testSources(t, func(t *testing.T, src Source) {
+		// entityIDs sorts the results, since the order of BRCA1 and Brca1
+		// depends on the database collation
+		got := entityIDs(src.QueryPrefix("brca", 10))
+		want := []string{"gene:12189", "gene:672"}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("QueryPrefix(brca) = %v, want %v", got, want)
+		}
+	})
+}
+
+// Fuzzy matches do not depend on the backend, including typos in the
+// first characters of a name.
+func TestSourceFuzzyQuery(t *testing.T) {
+	tests := []struct {
+		matcher string
+		text    string
+		want    []string
+	}{
+		{"levenshtein", "RBCA1", []string{"gene:12189", "gene:672"}},
+		{"levenshtein", "BRAC1", []string{"gene:12189", "gene:672"}},
+		{"levenshtein", "XP53", []string{"gene:7157"}},
+		{"jaro-winkler", "BRCA7", []string{"gene:12189", "gene:672"}},
+		{"jaro-winkler", "RBCA1", []string{"gene:12189", "gene:672"}},
+		{"trigram", "BRCA", []string{"gene:12189", "gene:672"}},
+		{"trigram", "RBCA1", []string{"gene:12189", "gene:672"}},
+		{"levenshtein", "QXZ1", nil},
+		{"none", "RBCA1", nil},
+	}
+	testSources(t, func(t *testing.T, src Source) {
+		for _, tc := range tests {
+			m, err := GetMatcher(tc.matcher)
+			if err != nil {
+				t.Fatal(err)
+			}
+			src.(interface{ SetMatcher(Matcher) }).SetMatcher(m)
+			res, err := src.Query(&QueryRequest{Text: tc.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, c := range res.Results {
+				got = append(got, string(c.ID))
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("%s %s: candidates = %v, want %v", tc.matcher, tc.text, got, tc.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,134 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Explanation describes how a query was answered, for debugging.
+type Explanation struct {
+	// Query that was explained.
+	Query *QueryRequest `json:"query"`
+
+	// Steps lists the lookups made to find candidates, in order.
+	Steps []*ExplainStep `json:"steps"`
+
+	// Candidates lists every candidate considered, in order.
+	Candidates []*ExplainCandidate `json:"candidates"`
+}
+
+// ExplainStep is a lookup made to find candidates for a query.
+type ExplainStep struct {
+	// Path names the lookup, e.g. "exact_id", "index:tokens" or "entity_search".
+	Path string `json:"path"`
+
+	// SQL is the query run for the lookup (for database sources).
+	SQL string `json:"sql,omitempty"`
+
+	// Args are the arguments to the SQL query.
+	Args []interface{} `json:"args,omitempty"`
+}
+
+// ExplainCandidate describes a candidate considered for a query.
+type ExplainCandidate struct {
+	*Candidate
+
+	// Path of the lookup that found the candidate.
+	Path string `json:"path"`
+
+	// Rank is the raw full-text search rank of the candidate (if any).
+	Rank *float64 `json:"rank,omitempty"`
+
+	// Kept is true if the candidate was returned for the query.
+	Kept bool `json:"kept"`
+
+	// Reason the candidate was kept or dropped.
+	Reason string `json:"reason"`
+}
+
+// Explain runs the query against the data source, and returns every
+// candidate it considered along with how it was found and scored.
+func Explain(ctx context.Context, src ContextSource, q *QueryRequest) (*Explanation, error) {
+	t := &explainTrace{
+		ex:          &Explanation{Query: q},
+		byCandidate: make(map[*Candidate]*ExplainCandidate),
+	}
+	res, err := src.QueryContext(context.WithValue(ctx, traceKey{}, t), q)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range res.Results {
+		ec, ok := t.byCandidate[c]
+		if !ok {
+			// the source does not trace its queries
+			ec = &ExplainCandidate{Candidate: c}
+			t.ex.Candidates = append(t.ex.Candidates, ec)
+		}
+		ec.Kept = true
+		ec.Reason = "returned"
+	}
+	for _, ec := range t.ex.Candidates {
+		if !ec.Kept && ec.Reason == "" {
+			ec.Reason = fmt.Sprintf("beyond the limit of %d results", q.Limit)
+		}
+	}
+	return t.ex, nil
+}
+
+type traceKey struct{}
+
+// explainTrace records the steps and candidates of a query being explained.
+type explainTrace struct {
+	mu          sync.Mutex
+	ex          *Explanation
+	step        *ExplainStep
+	byCandidate map[*Candidate]*ExplainCandidate
+}
+
+// traceFrom returns the trace of a query being explained, or nil. All
+// the trace methods do nothing on a nil trace.
+func traceFrom(ctx context.Context) *explainTrace {
+	t, _ := ctx.Value(traceKey{}).(*explainTrace)
+	return t
+}
+
+// Step records a lookup, which finds the candidates considered after it.
+func (t *explainTrace) Step(path, sql string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.step = &ExplainStep{Path: path, SQL: sql, Args: args}
+	t.ex.Steps = append(t.ex.Steps, t.step)
+}
+
+// Consider records a candidate found by the current step, along with its
+// raw full-text rank (if any).
+func (t *explainTrace) Consider(c *Candidate, rank *float64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ec := &ExplainCandidate{Candidate: c, Rank: rank}
+	if t.step != nil {
+		ec.Path = t.step.Path
+	}
+	t.byCandidate[c] = ec
+	t.ex.Candidates = append(t.ex.Candidates, ec)
+}
+
+// Drop records the reason a considered candidate was dropped.
+func (t *explainTrace) Drop(c *Candidate, reason string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ec, ok := t.byCandidate[c]; ok {
+		ec.Reason = reason
+	}
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"context"
+	"testing"
+)
+
+// untracedSource answers queries without passing the context along, so
+// nothing is traced.
+type untracedSource struct {
+	ContextSource
+}
+
+func (s untracedSource) QueryContext(ctx context.Context, q *QueryRequest) (*QueryResponse, error) {
+	return s.ContextSource.QueryContext(context.Background(), q)
+}
+
+func TestExplain(t *testing.T) {
+	type explained struct {
+		id     EntityID
+		kept   bool
+		reason string
+	}
+	tests := []struct {
+		name  string
+		q     *QueryRequest
+		step  string
+		cands []explained
+	}{
+		{"exact id", &QueryRequest{Text: "7157"}, "exact_id", []explained{
+			{"gene:7157", true, "returned"},
+		}},
+		{"retired id", &QueryRequest{Text: "100"}, "retired_id", []explained{
+			{"gene:672", true, "returned"},
+		}},
+		{"wrong type", &QueryRequest{Text: "7157", Type: TypeIDs{"protein"}}, "exact_id", []explained{
+			{"gene:7157", false, "does not have the query types [protein] (type_strict=any)"},
+		}},
+		{"limit", &QueryRequest{Text: "BRCA1", Properties: []*QueryProperty{{ID: "tax_id", Value: "9606"}}, Limit: 1}, "", []explained{
+			{"gene:672", true, "returned"},
+			{"gene:12189", false, "beyond the limit of 1 results"},
+		}},
+		{"no candidates", &QueryRequest{Text: "QXZ1"}, "", nil},
+	}
+	testSources(t, func(t *testing.T, src Source) {
+		for _, tc := range tests {
+			ex, err := Explain(context.Background(), src.(ContextSource), tc.q)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.name, err)
+			}
+			if len(ex.Steps) == 0 || (tc.step != "" && ex.Steps[0].Path != tc.step) {
+				t.Errorf("%s: steps = %v, want the first to be %q", tc.name, ex.Steps, tc.step)
+			}
+			got := make(map[EntityID]*ExplainCandidate)
+			for _, ec := range ex.Candidates {
+				if ec.Path == "" {
+					t.Errorf("%s: %s has no path", tc.name, ec.ID)
+				}
+				got[ec.ID] = ec
+			}
+			for _, want := range tc.cands {
+				ec, ok := got[want.id]
+				if !ok {
+					t.Errorf("%s: %s was not considered", tc.name, want.id)
+				} else if ec.Kept != want.kept || ec.Reason != want.reason {
+					t.Errorf("%s: %s kept=%v (%s), want kept=%v (%s)",
+						tc.name, want.id, ec.Kept, ec.Reason, want.kept, want.reason)
+				}
+			}
+			if tc.cands == nil && len(ex.Candidates) > 0 {
+				t.Errorf("%s: candidates = %v, want none", tc.name, ex.Candidates)
+			}
+		}
+	})
+}
+
+// Sources that do not trace their queries only explain the results.
+func TestExplainUntraced(t *testing.T) {
+	src, err := Load(writeFlatFile(t, testFlatFile...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := &QueryRequest{Text: "TP53"}
+	ex, err := Explain(context.Background(), untracedSource{src.(ContextSource)}, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ex.Steps) != 0 || len(ex.Candidates) != 1 {
+		t.Fatalf("Explain = %v steps, %v candidates, want only the result", ex.Steps, ex.Candidates)
+	}
+	if ec := ex.Candidates[0]; ec.ID != "gene:7157" || !ec.Kept || ec.Reason != "returned" || ec.Path != "" {
+		t.Errorf("Explain candidate = %+v, want gene:7157 returned", ec)
+	}
+	if ex.Query != q {
+		t.Errorf("Explain query = %v, want %v", ex.Query, q)
+	}
+}
@@ -0,0 +1,267 @@
+package model
+
+import (
+	"context"
+	"io"
+	"log"
+	"sort"
+)
+
+// FederatedSource represents several data sources behind a single Source.
+// Queries are routed to the sources by Type ID, and their results merged.
+type FederatedSource struct {
+	name                string
+	identifierNamespace string
+	schemaNamespace     string
+
+	sources []Source
+
+	// maps from Entity Type ID to the sources that support it.
+	byType map[string][]Source
+}
+
+// ensure it implements the interfaces
+var _ Source = &FederatedSource{}
+var _ ContextSource = &FederatedSource{}
+var _ io.Closer = &FederatedSource{}
+
+// NewFederatedSource returns a Source that merges the given data sources.
+// If the namespaces are blank, the ones from the first source are used.
+func NewFederatedSource(name, identifierNS, schemaNS string, sources ...Source) *FederatedSource {
+	f := &FederatedSource{
+		name:                name,
+		identifierNamespace: identifierNS,
+		schemaNamespace:     schemaNS,
+		sources:             sources,
+		byType:              make(map[string][]Source),
+	}
+	if len(sources) > 0 {
+		if f.identifierNamespace == "" {
+			f.identifierNamespace = sources[0].IdentifierNS()
+		}
+		if f.schemaNamespace == "" {
+			f.schemaNamespace = sources[0].SchemaNS()
+		}
+	}
+	for _, src := range sources {
+		for _, t := range src.Types() {
+			f.byType[t.ID] = append(f.byType[t.ID], src)
+		}
+	}
+	return f
+}
+
+// Name of the data Source.
+func (f *FederatedSource) Name() string {
+	return f.name
+}
+
+// IdentifierNS is a universal namespace for Entity identifiers.
+func (f *FederatedSource) IdentifierNS() string {
+	return f.identifierNamespace
+}
+
+// SchemaNS is a universal namespace for concept Type identifiers.
+func (f *FederatedSource) SchemaNS() string {
+	return f.schemaNamespace
+}
+
+// ViewURL returns the template for a View URL.
+// The first non-blank template of the federated sources is used.
+func (f *FederatedSource) ViewURL() string {
+	for _, src := range f.sources {
+		if vu := src.ViewURL(); vu != "" {
+			return vu
+		}
+	}
+	return ""
+}
+
+// Types returns the union of the Entity types of all sources.
+func (f *FederatedSource) Types() []*Type {
+	var res []*Type
+	seen := make(map[string]struct{})
+	for _, src := range f.sources {
+		for _, t := range src.Types() {
+			if _, ok := seen[t.ID]; ok {
+				continue
+			}
+			seen[t.ID] = struct{}{}
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// Properties returns the union of the Properties for Entities with
+// the Type ID given from all sources that support it.
+func (f *FederatedSource) Properties(typeID string) []*Property {
+	var res []*Property
+	seen := make(map[string]struct{})
+	for _, src := range f.byType[typeID] {
+		for _, p := range src.Properties(typeID) {
+			if _, ok := seen[p.ID]; ok {
+				continue
+			}
+			seen[p.ID] = struct{}{}
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// GetEntity returns the Entity matching the provided ID from the first
+// source that supports its Type and has it.
+func (f *FederatedSource) GetEntity(entityID EntityID) (*Entity, bool) {
+	e, err := f.GetEntityContext(context.Background(), entityID)
+	if err != nil {
+		if err != ErrNotFound {
+			log.Println(err)
+		}
+		return nil, false
+	}
+	return e, true
+}
+
+// GetEntityContext returns the Entity matching the provided ID from the
+// first source that supports its Type and has it, or ErrNotFound.
+func (f *FederatedSource) GetEntityContext(ctx context.Context, entityID EntityID) (*Entity, error) {
+	for _, src := range f.byType[entityID.Type()] {
+		e, err := WithContext(src).GetEntityContext(ctx, entityID)
+		if err == nil {
+			return e, nil
+		}
+		if err != ErrNotFound {
+			return nil, err
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// routeTypes returns the sources that should answer a query for the
+// Type IDs given. All sources are used if no Type IDs are given or
+// the types are not strictly required.
+func (f *FederatedSource) routeTypes(q *QueryRequest) []Source {
+	if len(q.Type) == 0 || q.Strictness == "should" {
+		return f.sources
+	}
+	var res []Source
+	seen := make(map[Source]struct{})
+	for _, tid := range q.Type {
+		for _, src := range f.byType[tid] {
+			if _, ok := seen[src]; ok {
+				continue
+			}
+			seen[src] = struct{}{}
+			res = append(res, src)
+		}
+	}
+	return res
+}
+
+// Query entitities for a match in each of the routed sources.
+func (f *FederatedSource) Query(q *QueryRequest) (*QueryResponse, error) {
+	return f.QueryContext(context.Background(), q)
+}
+
+// QueryContext queries entitities for a match in each of the routed sources.
+// The scores of each source are normalised before merging, and a candidate
+// is only a Match if it is the unique top candidate across all sources.
+func (f *FederatedSource) QueryContext(ctx context.Context, q *QueryRequest) (*QueryResponse, error) {
+	if q.Limit == 0 {
+		q.Limit = 25
+	}
+	res := &QueryResponse{
+		ID: q.ID,
+	}
+
+	trace := traceFrom(ctx)
+	for _, src := range f.routeTypes(q) {
+		trace.Step("source:"+src.Name(), "")
+		sq := *q
+		sr, err := WithContext(src).QueryContext(ctx, &sq)
+		if err != nil {
+			return nil, err
+		}
+		normalizeScores(sr.Results)
+		res.Results = append(res.Results, sr.Results...)
+	}
+
+	// ambiguous if the top candidates of different sources are close
+	res.Results = rankCandidates(res.Results, q.Limit)
+	return res, nil
+}
+
+// normalizeScores rescales the scores of a source's candidates to the
+// 0-100 range. Sources in this package already score candidates on the
+// same calibrated scale, and are not changed. Other sources with scores
+// above 100 are scaled down by their best score, and negative scores
+// become 0.
+func normalizeScores(results []*Candidate) {
+	top := 0.0
+	for _, c := range results {
+		if c.Score > top {
+			top = c.Score
+		}
+	}
+	for _, c := range results {
+		if top > 100.0 {
+			c.Score *= 100.0 / top
+		}
+		if c.Score < 0.0 {
+			c.Score = 0.0
+		}
+	}
+}
+
+// QueryPrefix searches entitities for a prefix match in all sources.
+func (f *FederatedSource) QueryPrefix(text string, limit int) []*Entity {
+	result, err := f.QueryPrefixContext(context.Background(), text, limit)
+	if err != nil {
+		log.Println(err)
+	}
+	return result
+}
+
+// QueryPrefixContext searches entitities for a prefix match in all sources.
+func (f *FederatedSource) QueryPrefixContext(ctx context.Context, text string, limit int) ([]*Entity, error) {
+	var result []*Entity
+	for _, src := range f.sources {
+		ents, err := WithContext(src).QueryPrefixContext(ctx, text, limit)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, ents...)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+// SetMatcher sets the fuzzy matching algorithm on every source
+// that supports fuzzy matching.
+func (f *FederatedSource) SetMatcher(m Matcher) {
+	for _, src := range f.sources {
+		if fs, ok := src.(interface{ SetMatcher(Matcher) }); ok {
+			fs.SetMatcher(m)
+		}
+	}
+}
+
+// Close closes every source that holds resources, and returns
+// the first error encountered.
+func (f *FederatedSource) Close() error {
+	var first error
+	for _, src := range f.sources {
+		if c, ok := src.(io.Closer); ok {
+			if err := c.Close(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"reflect"
+	"testing"
+)
+
+var proteinType = &Type{ID: "protein", Name: "Protein"}
+
+// testFederation returns a gene source and a protein source with the same
+// symbols, federated behind one source.
+func testFederation() *FederatedSource {
+	genes := newTestMemorySource(brca1,
+		&Entity{ID: "gene:7157", Name: "TP53", Types: []*Type{geneType}})
+	genes.name = "genes"
+	proteins := newTestMemorySource(
+		&Entity{ID: "protein:P38398", Name: "BRCA1", Types: []*Type{proteinType}},
+		&Entity{ID: "protein:Q9Y6K9", Name: "NEMO", Types: []*Type{proteinType}})
+	proteins.name = "proteins"
+	return NewFederatedSource("test", "", "", genes, proteins)
+}
+
+// candidateIDs returns the IDs of the candidates in ranked order.
+func candidateIDs(cs []*Candidate) []string {
+	var res []string
+	for _, c := range cs {
+		res = append(res, string(c.ID))
+	}
+	return res
+}
+
+func TestFederatedQuery(t *testing.T) {
+	f := testFederation()
+	tests := []struct {
+		name  string
+		q     *QueryRequest
+		want  []string
+		match string
+	}{
+		// routing by type
+		{"gene type", &QueryRequest{Text: "BRCA1", Type: TypeIDs{"gene"}},
+			[]string{"gene:672"}, "gene:672"},
+		{"protein type", &QueryRequest{Text: "BRCA1", Type: TypeIDs{"protein"}},
+			[]string{"protein:P38398"}, "protein:P38398"},
+		{"unknown type", &QueryRequest{Text: "BRCA1", Type: TypeIDs{"compound"}}, nil, ""},
+
+		// merging, where the same name in two sources is ambiguous
+		{"no type", &QueryRequest{Text: "BRCA1"},
+			[]string{"gene:672", "protein:P38398"}, ""},
+		{"both types", &QueryRequest{Text: "BRCA1", Type: TypeIDs{"gene", "protein"}},
+			[]string{"gene:672", "protein:P38398"}, ""},
+		// a missed "should" type lowers the score too little to decide
+		{"should", &QueryRequest{Text: "BRCA1", Type: TypeIDs{"gene"}, Strictness: "should"},
+			[]string{"gene:672", "protein:P38398"}, ""},
+		{"one source", &QueryRequest{Text: "NEMO"},
+			[]string{"protein:Q9Y6K9"}, "protein:Q9Y6K9"},
+		// ambiguity is decided before the limit
+		{"limit", &QueryRequest{Text: "BRCA1", Limit: 1}, []string{"gene:672"}, ""},
+	}
+	for _, tc := range tests {
+		res, err := f.Query(tc.q)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if got := candidateIDs(res.Results); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: candidates = %v, want %v", tc.name, got, tc.want)
+		}
+		for _, c := range res.Results {
+			if c.Match != (string(c.ID) == tc.match) {
+				t.Errorf("%s: %s match = %v, want match %q", tc.name, c.ID, c.Match, tc.match)
+			}
+		}
+	}
+}
+
+func TestNormalizeScores(t *testing.T) {
+	tests := []struct {
+		scores []float64
+		want   []float64
+	}{
+		// calibrated scores are unchanged
+		{[]float64{99, 60, 0}, []float64{99, 60, 0}},
+		{[]float64{100, 100}, []float64{100, 100}},
+		// other scales are fitted to 0-100
+		{[]float64{400, 200, 100}, []float64{100, 50, 25}},
+		{[]float64{50, -3}, []float64{50, 0}},
+		{nil, nil},
+	}
+	for _, tc := range tests {
+		var cs []*Candidate
+		for _, s := range tc.scores {
+			cs = append(cs, &Candidate{Score: s})
+		}
+		normalizeScores(cs)
+		for i, c := range cs {
+			if c.Score != tc.want[i] {
+				t.Errorf("normalizeScores(%v)[%d] = %v, want %v", tc.scores, i, c.Score, tc.want[i])
+			}
+		}
+	}
+}
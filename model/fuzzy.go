@@ -0,0 +1,322 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Matcher is a fuzzy string similarity algorithm, used to find and score
+// candidates that do not match the query text exactly (e.g. typos).
+type Matcher interface {
+	// Name of the matching algorithm.
+	Name() string
+
+	// Similarity returns a value between 0 (nothing in common)
+	// and 1 (identical) for the two strings.
+	Similarity(a, b string) float64
+
+	// MinSimilarity is the smallest Similarity value for two strings
+	// to be considered a fuzzy match.
+	MinSimilarity() float64
+}
+
+// Matchers lists all the available fuzzy matching algorithms by name.
+var Matchers = map[string]Matcher{
+	"levenshtein":  Levenshtein{},
+	"jaro-winkler": JaroWinkler{},
+	"trigram":      Trigram{},
+}
+
+// GetMatcher returns the fuzzy matching algorithm with the given name.
+// The names "" and "none" return a nil Matcher, which disables fuzzy matching.
+func GetMatcher(name string) (Matcher, error) {
+	if name == "" || name == "none" {
+		return nil, nil
+	}
+	m, ok := Matchers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("recongo.model: unknown matcher '%s'", name)
+	}
+	return m, nil
+}
+
+// Levenshtein similarity is the edit distance between two strings,
+// normalized by the length of the longer string.
+type Levenshtein struct{}
+
+// Name of the matching algorithm.
+func (Levenshtein) Name() string { return "levenshtein" }
+
+// MinSimilarity is the smallest Similarity value considered a fuzzy match.
+func (Levenshtein) MinSimilarity() float64 { return 0.6 }
+
+// Similarity returns 1 - (edit distance / length of the longer string).
+func (Levenshtein) Similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	n := len(ra)
+	if len(rb) > n {
+		n = len(rb)
+	}
+	if n == 0 {
+		return 1.0
+	}
+	return 1.0 - float64(levenshteinDistance(ra, rb))/float64(n)
+}
+
+// maxSimilarity is at most the ratio of the lengths, since every
+// extra character needs an edit.
+func (Levenshtein) maxSimilarity(n, m int) float64 {
+	if n > m {
+		n, m = m, n
+	}
+	return float64(n) / float64(m)
+}
+
+func levenshteinDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// JaroWinkler similarity favors strings that share a common prefix,
+// which suits short identifiers and symbols.
+type JaroWinkler struct{}
+
+// Name of the matching algorithm.
+func (JaroWinkler) Name() string { return "jaro-winkler" }
+
+// MinSimilarity is the smallest Similarity value considered a fuzzy match.
+func (JaroWinkler) MinSimilarity() float64 { return 0.85 }
+
+// Similarity returns the Jaro-Winkler similarity of the two strings.
+func (JaroWinkler) Similarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) == 0 && len(rb) == 0 {
+		return 1.0
+	}
+	if len(ra) == 0 || len(rb) == 0 {
+		return 0.0
+	}
+
+	window := len(ra)
+	if len(rb) > window {
+		window = len(rb)
+	}
+	window = window/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	ma := make([]bool, len(ra))
+	mb := make([]bool, len(rb))
+	matches := 0
+	for i := range ra {
+		lo, hi := i-window, i+window+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(rb) {
+			hi = len(rb)
+		}
+		for j := lo; j < hi; j++ {
+			if !mb[j] && ra[i] == rb[j] {
+				ma[i], mb[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0.0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := range ra {
+		if !ma[i] {
+			continue
+		}
+		for !mb[j] {
+			j++
+		}
+		if ra[i] != rb[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(ra)) + m/float64(len(rb)) + (m-float64(transpositions)/2)/m) / 3.0
+
+	prefix := 0
+	for prefix < 4 && prefix < len(ra) && prefix < len(rb) && ra[prefix] == rb[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1.0-jaro)
+}
+
+// maxSimilarity assumes every character of the shorter string matches
+// without transpositions, with the longest prefix bonus.
+func (JaroWinkler) maxSimilarity(n, m int) float64 {
+	if n > m {
+		n, m = m, n
+	}
+	jaro := (2.0 + float64(n)/float64(m)) / 3.0
+	return jaro + 0.4*(1.0-jaro)
+}
+
+// Trigram similarity is the Dice coefficient of the character
+// trigrams of two strings, which tolerates reordered words.
+type Trigram struct{}
+
+// Name of the matching algorithm.
+func (Trigram) Name() string { return "trigram" }
+
+// MinSimilarity is the smallest Similarity value considered a fuzzy match.
+func (Trigram) MinSimilarity() float64 { return 0.3 }
+
+// Similarity returns the Dice coefficient of the trigrams of both strings.
+func (Trigram) Similarity(a, b string) float64 {
+	ta, tb := trigrams(a), trigrams(b)
+	if len(ta) == 0 && len(tb) == 0 {
+		return 1.0
+	}
+	counts := make(map[string]int, len(ta))
+	for _, t := range ta {
+		counts[t]++
+	}
+	shared := 0
+	for _, t := range tb {
+		if counts[t] > 0 {
+			counts[t]--
+			shared++
+		}
+	}
+	return float64(2*shared) / float64(len(ta)+len(tb))
+}
+
+// maxSimilarity assumes every trigram of the shorter string is shared.
+// A string of n characters has n+1 padded trigrams.
+func (Trigram) maxSimilarity(n, m int) float64 {
+	if n > m {
+		n, m = m, n
+	}
+	return float64(2*(n+1)) / float64(n+m+2)
+}
+
+// trigrams returns the padded character trigrams of s.
+func trigrams(s string) []string {
+	r := []rune("  " + s + " ")
+	if len(r) < 3 {
+		return nil
+	}
+	res := make([]string, 0, len(r)-2)
+	for i := 0; i+3 <= len(r); i++ {
+		res = append(res, string(r[i:i+3]))
+	}
+	return res
+}
+
+// candidateTrigrams returns the trigrams of s used to find fuzzy match
+// candidates. The trigrams padded with two spaces are left out, since
+// they are shared by every term that starts with the same character.
+func candidateTrigrams(s string) []string {
+	var res []string
+	for _, t := range trigrams(s) {
+		if !strings.HasPrefix(t, "  ") {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// lengthBounder is implemented by the Matchers that can bound the
+// similarity of two strings by their lengths alone.
+type lengthBounder interface {
+	// maxSimilarity returns the largest Similarity possible for strings
+	// of n and m characters.
+	maxSimilarity(n, m int) float64
+}
+
+// lengthRange returns the shortest and longest lengths of a term that
+// can be similar enough to a token of n characters according to the
+// Matcher. The longest length is -1 if there is no bound.
+func lengthRange(m Matcher, n int) (int, int) {
+	lb, ok := m.(lengthBounder)
+	if !ok {
+		return 1, -1
+	}
+	lo := n
+	for lo > 1 && lb.maxSimilarity(n, lo-1) >= m.MinSimilarity() {
+		lo--
+	}
+	hi := n
+	for lb.maxSimilarity(n, hi+1) >= m.MinSimilarity() {
+		hi++
+	}
+	return lo, hi
+}
+
+// inLengthRange returns true if term has between lo and hi characters
+// (with no upper bound if hi is -1).
+func inLengthRange(term string, lo, hi int) bool {
+	n := utf8.RuneCountInString(term)
+	return n >= lo && (hi < 0 || n <= hi)
+}
+
+// bestFuzzyTerms returns up to n of the terms that are similar enough
+// to text according to the Matcher, most similar first. Terms outside
+// the length range for text are skipped without comparing them.
+func bestFuzzyTerms(m Matcher, text string, terms []string, n int) []string {
+	type scoredTerm struct {
+		term string
+		sim  float64
+	}
+	lo, hi := lengthRange(m, utf8.RuneCountInString(text))
+	var hits []scoredTerm
+	for _, t := range terms {
+		if !inLengthRange(t, lo, hi) {
+			continue
+		}
+		if sim := m.Similarity(text, t); sim >= m.MinSimilarity() {
+			hits = append(hits, scoredTerm{t, sim})
+		}
+	}
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].sim > hits[j].sim
+	})
+	if len(hits) > n {
+		hits = hits[:n]
+	}
+	res := make([]string, len(hits))
+	for i, h := range hits {
+		res[i] = h.term
+	}
+	return res
+}
@@ -0,0 +1,145 @@
+package model
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b                  string
+		lev, jaroWink, trigrm float64
+	}{
+		{"brca1", "brca1", 1, 1, 1},
+		{"", "", 1, 1, 1},
+		{"abc", "", 0, 0, 0},
+		{"brca1", "xyz", 0, 0, 0},
+		{"brca1", "brca2", 0.8, 0.92, 0.6667},
+		{"brca1", "brac1", 0.6, 0.9467, 0.3333},
+		{"martha", "marhta", 0.6667, 0.9611, 0.4286},
+		{"dixon", "dicksonx", 0.5, 0.8133, 0.2667},
+		{"tp53", "p53", 0.75, 0.9167, 0.4444},
+		{"tumor protein", "protein tumor", 0.0769, 0.5971, 0.8571},
+	}
+	for _, tc := range tests {
+		for _, m := range []struct {
+			m    Matcher
+			want float64
+		}{
+			{Levenshtein{}, tc.lev},
+			{JaroWinkler{}, tc.jaroWink},
+			{Trigram{}, tc.trigrm},
+		} {
+			got := m.m.Similarity(tc.a, tc.b)
+			if math.Abs(got-m.want) > 0.00005 {
+				t.Errorf("%s(%q, %q) = %.4f, want %.4f", m.m.Name(), tc.a, tc.b, got, m.want)
+			}
+			if rev := m.m.Similarity(tc.b, tc.a); rev != got {
+				t.Errorf("%s(%q, %q) = %.4f, not symmetric", m.m.Name(), tc.b, tc.a, rev)
+			}
+		}
+	}
+}
+
+func TestCandidateTrigrams(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"brca1", []string{" br", "brc", "rca", "ca1", "a1 "}},
+		{"x", []string{" x "}},
+		{"", nil},
+	}
+	for _, tc := range tests {
+		if got := candidateTrigrams(tc.s); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("candidateTrigrams(%q) = %q, want %q", tc.s, got, tc.want)
+		}
+	}
+}
+
+func TestLengthRange(t *testing.T) {
+	tests := []struct {
+		m      Matcher
+		n      int
+		lo, hi int
+	}{
+		{Levenshtein{}, 1, 1, 1},
+		{Levenshtein{}, 5, 3, 8},
+		{Levenshtein{}, 10, 6, 16},
+		{JaroWinkler{}, 5, 2, 20},
+		{Trigram{}, 5, 1, 33},
+	}
+	for _, tc := range tests {
+		lo, hi := lengthRange(tc.m, tc.n)
+		if lo != tc.lo || hi != tc.hi {
+			t.Errorf("lengthRange(%s, %d) = %d, %d, want %d, %d", tc.m.Name(), tc.n, lo, hi, tc.lo, tc.hi)
+		}
+	}
+}
+
+// The length range must never exclude a term that is similar enough.
+func TestLengthRangeBoundsSimilarity(t *testing.T) {
+	words := []string{"a", "ab", "brca", "brca1", "brca12", "tp53bp1",
+		"brcaxxxxx1", "tumorprotein", "aaaaaaaaaaaaaaaaaaaa"}
+	for _, m := range []Matcher{Levenshtein{}, JaroWinkler{}, Trigram{}} {
+		for _, a := range words {
+			lo, hi := lengthRange(m, len(a))
+			for _, b := range words {
+				if m.Similarity(a, b) >= m.MinSimilarity() && !inLengthRange(b, lo, hi) {
+					t.Errorf("%s: %q is similar to %q but outside %d-%d", m.Name(), b, a, lo, hi)
+				}
+			}
+		}
+	}
+}
+
+func TestIndexFuzzySearch(t *testing.T) {
+	idx := newMemoryIndex(testEntities())
+	tests := []struct {
+		m    Matcher
+		text string
+		want []string
+	}{
+		{Levenshtein{}, "brac1", []string{"gene:672"}},
+		{Levenshtein{}, "bcra1", []string{"gene:672"}},
+		{Levenshtein{}, "tumour", []string{"gene:ENSG1"}},
+		{Levenshtein{}, "tp35", nil},
+		{JaroWinkler{}, "tp35", []string{"gene:7157"}},
+		{Trigram{}, "brac1", []string{"gene:672", "gene:675"}},
+		// no trigram is shared with a single character
+		{Levenshtein{}, "q", nil},
+	}
+	for _, tc := range tests {
+		got := entityIDs(idx.Search(tc.text, tc.m))
+		if len(got) == 0 && len(tc.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Search(%q, %s) = %v, want %v", tc.text, tc.m.Name(), got, tc.want)
+		}
+	}
+}
+
+// Fuzzy matches are only searched when there is no good hit.
+func TestQueryFuzzyFallback(t *testing.T) {
+	ents := testEntities()
+	src := newTestMemorySource(ents["672"][0], ents["675"][0])
+	src.SetMatcher(Levenshtein{})
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"brca2", []string{"gene:675"}},
+		{"brac1", []string{"gene:672"}},
+	}
+	for _, tc := range tests {
+		res, err := src.Query(&QueryRequest{Text: tc.text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := candidateIDs(res.Results); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Query(%q) = %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
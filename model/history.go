@@ -0,0 +1,51 @@
+package model
+
+import (
+	"context"
+	"fmt"
+)
+
+// maximum number of replacements to follow when resolving a retired
+// Entity ID to its current Entity, which also guards against cycles.
+const maxRedirects = 10
+
+// exactCandidates returns exact ID match Candidates for the entities that
+// are allowed by the query types, ranked so that an ID shared by several
+// entities is not a Match. If retiredID is not blank, the entities
+// replaced it and the candidates are flagged as redirects from it.
+func exactCandidates(ctx context.Context, q *QueryRequest, ents []*Entity, retiredID string) []*Candidate {
+	trace := traceFrom(ctx)
+	var res []*Candidate
+	for _, e := range ents {
+		c := &Candidate{
+			ID:    e.ID,
+			Name:  e.Name,
+			Types: e.Types,
+		}
+		if retiredID != "" {
+			c.Redirect = true
+			c.RedirectedFrom = EntityID(e.ID.Type() + ":" + retiredID)
+		}
+		trace.Consider(c, nil)
+
+		f := newFeatures(q, e.ID, e.Name, e.Aliases, nil)
+		f.idExact = true
+		keep := f.scoreTypes(q, e.Types)
+		f.Apply(q, c)
+		if !keep {
+			trace.Drop(c, typeMismatchReason(q))
+			continue
+		}
+		res = append(res, c)
+	}
+	return rankCandidates(res, 0)
+}
+
+// typeMismatchReason explains why a candidate was dropped for its types.
+func typeMismatchReason(q *QueryRequest) string {
+	strict := q.Strictness
+	if strict == "" {
+		strict = "any"
+	}
+	return fmt.Sprintf("does not have the query types %v (type_strict=%s)", []string(q.Type), strict)
+}
@@ -0,0 +1,104 @@
+package model
+
+import (
+	"testing"
+)
+
+func TestRetiredIDs(t *testing.T) {
+	fn := writeFlatFile(t, testHeader,
+		"672\tBRCA1\tgene\t{}\n",
+		// a chain of replacements
+		"100\t200\treplaced_by,gene\t{}\n",
+		"200\t672\treplaced_by,gene\t{}\n",
+		// a cycle without a current entity
+		"300\t301\treplaced_by,gene\t{}\n",
+		"301\t300\treplaced_by,gene\t{}\n",
+		// replaced by an entity that does not exist
+		"400\t999\treplaced_by,gene\t{}\n",
+	)
+	src, err := Load(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		text     string
+		want     EntityID
+		redirect bool
+	}{
+		{"100", "gene:672", true},
+		{"200", "gene:672", true},
+		{"300", "", false},
+		{"301", "", false},
+		{"400", "", false},
+		// current IDs are not redirects
+		{"672", "gene:672", false},
+		{"", "", false},
+	}
+	for _, tc := range tests {
+		e, ok := src.GetEntity(EntityID("gene:" + tc.text))
+		if tc.want == "" {
+			if ok {
+				t.Errorf("GetEntity(gene:%s) = %s, want none", tc.text, e.ID)
+			}
+		} else if !ok || e.ID != tc.want {
+			t.Errorf("GetEntity(gene:%s) = %v, want %s", tc.text, e, tc.want)
+		}
+
+		res, err := src.Query(&QueryRequest{Text: tc.text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var redirects []*Candidate
+		for _, c := range res.Results {
+			if c.Redirect {
+				redirects = append(redirects, c)
+			}
+		}
+		if !tc.redirect {
+			if len(redirects) > 0 {
+				t.Errorf("Query(%q) redirects to %s, want none", tc.text, redirects[0].ID)
+			}
+			continue
+		}
+		if len(redirects) != 1 || redirects[0].ID != tc.want || !redirects[0].Match ||
+			redirects[0].RedirectedFrom != EntityID("gene:"+tc.text) {
+			t.Errorf("Query(%q) = %+v, want a matched redirect to %s", tc.text, res.Results, tc.want)
+		}
+	}
+}
+
+func TestSourceRedirect(t *testing.T) {
+	tests := []struct {
+		q    *QueryRequest
+		want EntityID
+	}{
+		{&QueryRequest{Text: "100"}, "gene:672"},
+		{&QueryRequest{Text: "100", Type: TypeIDs{"gene"}}, "gene:672"},
+		{&QueryRequest{Text: "100", Type: TypeIDs{"protein"}}, ""},
+		{&QueryRequest{Text: "672"}, ""},
+	}
+	testSources(t, func(t *testing.T, src Source) {
+		for _, tc := range tests {
+			res, err := src.Query(tc.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got *Candidate
+			for _, c := range res.Results {
+				if c.Redirect {
+					got = c
+				}
+			}
+			switch {
+			case tc.want == "" && got != nil:
+				t.Errorf("Query(%q, %v) redirects to %s, want none", tc.q.Text, tc.q.Type, got.ID)
+			case tc.want != "" && got == nil:
+				t.Errorf("Query(%q, %v) has no redirect, want %s", tc.q.Text, tc.q.Type, tc.want)
+			case tc.want != "" && (got.ID != tc.want || got.RedirectedFrom != "gene:"+EntityID(tc.q.Text)):
+				t.Errorf("Query(%q, %v) redirects from %s to %s, want from gene:%s to %s",
+					tc.q.Text, tc.q.Type, got.RedirectedFrom, got.ID, tc.q.Text, tc.want)
+			}
+		}
+	})
+}
@@ -0,0 +1,304 @@
+package model
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// memoryIndex is an inverted index over the entities of a MemorySource,
+// so that name and prefix lookups scale with the number of hits instead
+// of the number of entities loaded.
+type memoryIndex struct {
+	// terms is the sorted list of unique lowercase name and alias tokens.
+	terms []string
+
+	// postings[i] lists the entities whose name or aliases contain terms[i].
+	postings [][]*Entity
+
+	// lowNames is the sorted list of lowercase entity names.
+	lowNames []string
+
+	// names[i] is the entity named lowNames[i].
+	names []*Entity
+
+	// maps from lowercase raw Entity ID to Entities, only for
+	// IDs that are not already lowercase.
+	lowerIDs map[string][]*Entity
+
+	// maps from character trigram to the indexes of terms containing it,
+	// for the trigrams used to find fuzzy match candidates.
+	termTrigrams map[string][]int
+}
+
+// tokenize splits text into lowercase alphanumeric tokens.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// newMemoryIndex builds an index over all the given entities.
+func newMemoryIndex(entities map[string][]*Entity) *memoryIndex {
+	idx := &memoryIndex{
+		lowerIDs: make(map[string][]*Entity),
+	}
+	tokens := make(map[string][]*Entity)
+	for rawID, ents := range entities {
+		if low := strings.ToLower(rawID); low != rawID {
+			idx.lowerIDs[low] = append(idx.lowerIDs[low], ents...)
+		}
+		for _, e := range ents {
+			idx.names = append(idx.names, e)
+			idx.lowNames = append(idx.lowNames, strings.ToLower(e.Name))
+
+			seen := make(map[string]struct{})
+			for _, name := range append([]string{e.Name}, e.Aliases...) {
+				for _, tok := range tokenize(name) {
+					if _, ok := seen[tok]; ok {
+						continue
+					}
+					seen[tok] = struct{}{}
+					tokens[tok] = append(tokens[tok], e)
+				}
+			}
+		}
+	}
+
+	idx.terms = make([]string, 0, len(tokens))
+	for tok := range tokens {
+		idx.terms = append(idx.terms, tok)
+	}
+	sort.Strings(idx.terms)
+	idx.postings = make([][]*Entity, len(idx.terms))
+	for i, tok := range idx.terms {
+		idx.postings[i] = tokens[tok]
+	}
+	idx.termTrigrams = newTermTrigrams(idx.terms)
+
+	sort.Sort(byLowName{idx})
+	return idx
+}
+
+// byLowName sorts the names and lowNames of an index together.
+type byLowName struct {
+	idx *memoryIndex
+}
+
+func (x byLowName) Len() int           { return len(x.idx.names) }
+func (x byLowName) Less(i, j int) bool { return x.idx.lowNames[i] < x.idx.lowNames[j] }
+func (x byLowName) Swap(i, j int) {
+	x.idx.names[i], x.idx.names[j] = x.idx.names[j], x.idx.names[i]
+	x.idx.lowNames[i], x.idx.lowNames[j] = x.idx.lowNames[j], x.idx.lowNames[i]
+}
+
+// termRange returns the range of terms that start with prefix.
+func (idx *memoryIndex) termRange(prefix string) (int, int) {
+	lo := sort.SearchStrings(idx.terms, prefix)
+	hi := lo
+	for hi < len(idx.terms) && strings.HasPrefix(idx.terms[hi], prefix) {
+		hi++
+	}
+	return lo, hi
+}
+
+// fuzzyTerms returns the indexes of the terms that are similar to tok
+// according to the Matcher. Only the terms that share a trigram with tok
+// and are in its length range are compared.
+func (idx *memoryIndex) fuzzyTerms(m Matcher, tok string) []int {
+	var res []int
+	for _, i := range trigramCandidates(m, tok, idx.terms, idx.termTrigrams) {
+		if m.Similarity(tok, idx.terms[i]) >= m.MinSimilarity() {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// newTermTrigrams maps each trigram used to find fuzzy match candidates
+// to the indexes of the terms containing it.
+func newTermTrigrams(terms []string) map[string][]int {
+	res := make(map[string][]int)
+	for i, tok := range terms {
+		for _, tg := range candidateTrigrams(tok) {
+			tt := res[tg]
+			if len(tt) == 0 || tt[len(tt)-1] != i {
+				res[tg] = append(tt, i)
+			}
+		}
+	}
+	return res
+}
+
+// trigramCandidates returns the indexes of the terms that share a trigram
+// with tok and are in its length range for the Matcher, which are the
+// only terms that can be similar enough to it.
+func trigramCandidates(m Matcher, tok string, terms []string, termTrigrams map[string][]int) []int {
+	lo, hi := lengthRange(m, utf8.RuneCountInString(tok))
+	seen := make(map[int]struct{})
+	var res []int
+	for _, tg := range candidateTrigrams(tok) {
+		for _, i := range termTrigrams[tg] {
+			if _, ok := seen[i]; ok {
+				continue
+			}
+			seen[i] = struct{}{}
+			if inLengthRange(terms[i], lo, hi) {
+				res = append(res, i)
+			}
+		}
+	}
+	return res
+}
+
+// tokenMatch lists the terms that a single query token matches.
+type tokenMatch struct {
+	tok string
+
+	// lo and hi are the range of terms with tok as a prefix.
+	lo, hi int
+
+	// fuzzy lists the terms similar to tok.
+	fuzzy map[string]int
+}
+
+// Matches returns true if the name token nt is matched.
+func (t *tokenMatch) Matches(nt string) bool {
+	if strings.HasPrefix(nt, t.tok) {
+		return true
+	}
+	_, ok := t.fuzzy[nt]
+	return ok
+}
+
+// Search returns all entities whose name (or one of its aliases) has a
+// token starting with each of the tokens in text. If a Matcher is given, tokens that
+// are similar to the query tokens also match.
+func (idx *memoryIndex) Search(text string, m Matcher) []*Entity {
+	qtoks := tokenize(text)
+	if len(qtoks) == 0 {
+		return nil
+	}
+
+	// start from the query token with the fewest postings
+	matches := make([]*tokenMatch, len(qtoks))
+	best, bestCount := -1, 0
+	for i, tok := range qtoks {
+		tm := &tokenMatch{tok: tok}
+		tm.lo, tm.hi = idx.termRange(tok)
+		n := 0
+		for j := tm.lo; j < tm.hi; j++ {
+			n += len(idx.postings[j])
+		}
+		if m != nil {
+			tm.fuzzy = make(map[string]int)
+			for _, j := range idx.fuzzyTerms(m, tok) {
+				if j >= tm.lo && j < tm.hi {
+					continue
+				}
+				tm.fuzzy[idx.terms[j]] = j
+				n += len(idx.postings[j])
+			}
+		}
+		if n == 0 {
+			return nil
+		}
+		matches[i] = tm
+		if best == -1 || n < bestCount {
+			best, bestCount = i, n
+		}
+	}
+
+	seen := make(map[*Entity]struct{}, bestCount)
+	var result []*Entity
+	addPostings := func(j int) {
+		for _, e := range idx.postings[j] {
+			if _, ok := seen[e]; ok {
+				continue
+			}
+			seen[e] = struct{}{}
+			if len(qtoks) > 1 && !entityMatchesTokens(e, matches, best) {
+				continue
+			}
+			result = append(result, e)
+		}
+	}
+	for j := matches[best].lo; j < matches[best].hi; j++ {
+		addPostings(j)
+	}
+	for _, j := range matches[best].fuzzy {
+		addPostings(j)
+	}
+	return result
+}
+
+// entityMatchesTokens returns true if the name or one of the aliases
+// of the entity matches every query token (except the one at index skip).
+func entityMatchesTokens(e *Entity, matches []*tokenMatch, skip int) bool {
+	if matchesTokens(e.Name, matches, skip) {
+		return true
+	}
+	for _, a := range e.Aliases {
+		if matchesTokens(a, matches, skip) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesTokens returns true if every query token match (except the one
+// at index skip) matches some token in name.
+func matchesTokens(name string, matches []*tokenMatch, skip int) bool {
+	ntoks := tokenize(name)
+	for i, tm := range matches {
+		if i == skip {
+			continue
+		}
+		found := false
+		for _, nt := range ntoks {
+			if tm.Matches(nt) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTokenPrefixes returns true if every token of text is a prefix
+// of some token in name.
+func hasTokenPrefixes(name, text string) bool {
+	qtoks := tokenize(text)
+	matches := make([]*tokenMatch, len(qtoks))
+	for i, tok := range qtoks {
+		matches[i] = &tokenMatch{tok: tok}
+	}
+	return matchesTokens(name, matches, -1)
+}
+
+// Prefix returns up to limit entities whose name starts with text,
+// in name order.
+func (idx *memoryIndex) Prefix(text string, limit int) []*Entity {
+	low := strings.ToLower(text)
+	var result []*Entity
+	i := sort.SearchStrings(idx.lowNames, low)
+	for ; i < len(idx.lowNames) && len(result) < limit; i++ {
+		if !strings.HasPrefix(idx.lowNames[i], low) {
+			break
+		}
+		result = append(result, idx.names[i])
+	}
+	return result
+}
+
+// ByLowerID returns the entities whose raw ID matches id case-insensitively.
+func (idx *memoryIndex) ByLowerID(id string, entities map[string][]*Entity) []*Entity {
+	low := strings.ToLower(id)
+	var result []*Entity
+	result = append(result, entities[low]...)
+	return append(result, idx.lowerIDs[low]...)
+}
@@ -0,0 +1,214 @@
+package model
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var geneType = &Type{ID: "gene", Name: "Gene"}
+
+// testEntities returns a few genes keyed by raw ID, as in MemorySource.
+func testEntities() map[string][]*Entity {
+	res := make(map[string][]*Entity)
+	for _, e := range []*Entity{
+		{ID: "gene:672", Name: "BRCA1", Aliases: []string{"BRCAI", "BRCC1", "IRIS"}},
+		{ID: "gene:675", Name: "BRCA2", Aliases: []string{"FANCD1"}},
+		{ID: "gene:7157", Name: "TP53", Aliases: []string{"P53", "LFS1"}},
+		{ID: "gene:7158", Name: "TP53BP1", Aliases: []string{"p202"}},
+		{ID: "gene:ENSG1", Name: "tumor protein p63"},
+	} {
+		e.Types = []*Type{geneType}
+		res[e.ID.ID()] = append(res[e.ID.ID()], e)
+	}
+	return res
+}
+
+// entityIDs returns the sorted IDs of the entities.
+func entityIDs(ents []*Entity) []string {
+	res := make([]string, 0, len(ents))
+	for _, e := range ents {
+		res = append(res, string(e.ID))
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestIndexSearch(t *testing.T) {
+	idx := newMemoryIndex(testEntities())
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"BRCA1", []string{"gene:672"}},
+		{"brca", []string{"gene:672", "gene:675"}},
+		{"tp53", []string{"gene:7157", "gene:7158"}},
+		// aliases are indexed too
+		{"fancd1", []string{"gene:675"}},
+		{"p53", []string{"gene:7157"}},
+		// every query token must start a token of the name (or one alias)
+		{"tumor p6", []string{"gene:ENSG1"}},
+		{"protein tumor", []string{"gene:ENSG1"}},
+		{"tumor brca1", nil},
+		// tokens match from their start, unlike the substring
+		// matching of the linear scan
+		{"RCA1", nil},
+		{"53", nil},
+		{"", nil},
+		{"--", nil},
+	}
+	for _, tc := range tests {
+		got := entityIDs(idx.Search(tc.text, nil))
+		if len(got) == 0 && len(tc.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Search(%q) = %v, want %v", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestIndexPrefix(t *testing.T) {
+	idx := newMemoryIndex(testEntities())
+	tests := []struct {
+		text  string
+		limit int
+		want  []string
+	}{
+		{"brca", 10, []string{"BRCA1", "BRCA2"}},
+		{"BRCA", 1, []string{"BRCA1"}},
+		{"tp53", 10, []string{"TP53", "TP53BP1"}},
+		{"Tumor", 10, []string{"tumor protein p63"}},
+		// names only, not aliases or inner tokens
+		{"fancd", 10, nil},
+		{"protein", 10, nil},
+		{"x", 10, nil},
+	}
+	for _, tc := range tests {
+		var got []string
+		for _, e := range idx.Prefix(tc.text, tc.limit) {
+			got = append(got, e.Name)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("Prefix(%q, %d) = %v, want %v", tc.text, tc.limit, got, tc.want)
+		}
+	}
+}
+
+func TestIndexByLowerID(t *testing.T) {
+	ents := testEntities()
+	idx := newMemoryIndex(ents)
+	tests := []struct {
+		id   string
+		want []string
+	}{
+		{"672", []string{"gene:672"}},
+		{"ensg1", []string{"gene:ENSG1"}},
+		{"EnSg1", []string{"gene:ENSG1"}},
+		{"ENSG1", []string{"gene:ENSG1"}},
+		{"673", nil},
+	}
+	for _, tc := range tests {
+		got := entityIDs(idx.ByLowerID(tc.id, ents))
+		if len(got) == 0 && len(tc.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("ByLowerID(%q) = %v, want %v", tc.id, got, tc.want)
+		}
+	}
+}
+
+// benchEntities returns n generated entities keyed by raw ID.
+func benchEntities(n int) map[string][]*Entity {
+	res := make(map[string][]*Entity, n)
+	for i := 0; i < n; i++ {
+		e := &Entity{
+			ID:    EntityID(fmt.Sprintf("gene:%d", i)),
+			Name:  fmt.Sprintf("GENE%d", i),
+			Types: []*Type{geneType},
+		}
+		if i%10 == 0 {
+			e.Name += " family member"
+		}
+		res[e.ID.ID()] = append(res[e.ID.ID()], e)
+	}
+	return res
+}
+
+// linearSearch is the full scan that MemorySource.Query used before the
+// index: a case-insensitive substring match on every entity name.
+func linearSearch(entities map[string][]*Entity, text string) []*Entity {
+	low := strings.ToLower(text)
+	var res []*Entity
+	for _, ents := range entities {
+		for _, e := range ents {
+			if strings.Contains(strings.ToLower(e.Name), low) {
+				res = append(res, e)
+			}
+		}
+	}
+	return res
+}
+
+// linearPrefix is the full scan that MemorySource.QueryPrefix used
+// before the index.
+func linearPrefix(entities map[string][]*Entity, text string, limit int) []*Entity {
+	low := strings.ToLower(text)
+	var res []*Entity
+	for _, ents := range entities {
+		for _, e := range ents {
+			if strings.HasPrefix(strings.ToLower(e.Name), low) {
+				res = append(res, e)
+				if len(res) >= limit {
+					return res
+				}
+			}
+		}
+	}
+	return res
+}
+
+const benchSize = 100000
+
+func BenchmarkSearchLinear(b *testing.B) {
+	ents := benchEntities(benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearSearch(ents, "gene4242")
+	}
+}
+
+func BenchmarkSearchIndex(b *testing.B) {
+	idx := newMemoryIndex(benchEntities(benchSize))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Search("gene4242", nil)
+	}
+}
+
+func BenchmarkPrefixLinear(b *testing.B) {
+	ents := benchEntities(benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linearPrefix(ents, "gene9999", 10)
+	}
+}
+
+func BenchmarkPrefixIndex(b *testing.B) {
+	idx := newMemoryIndex(benchEntities(benchSize))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		idx.Prefix("gene9999", 10)
+	}
+}
+
+func BenchmarkIndexBuild(b *testing.B) {
+	ents := benchEntities(benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		newMemoryIndex(ents)
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"bufio"
 	"compress/gzip"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
 )
 
-// Load a data source from a flat file.
-// Format is tab-separated values in 4 columns with a 1-line header.
+// Load a data source from a flat file, a sqlite database (if the filename
+// contains "sqlite"), or a postgres connection string ("postgres://...").
+// Flat file format is tab-separated values in 4 columns with a 1-line header.
 //
 // Header:
 //    0: Identifier Namespace URI
@@ -21,14 +25,67 @@ import (
 //    0: Property ID
 //    1: Name of the Property
 //    2: comma-separated list of "property" + Entity Type IDs it applies to
-//    3: JSON object of property settings {description: "", ...}
+//    3: JSON object of property settings {description: "", type: "str", ...}
 // Entities:
 //    0: Entity ID
 //    1: Entity Name
 //    2: comma-separated list of Entity Type IDs
-//    3: JSON object of properties {description: "", ...}
+//    3: JSON object of properties {description: "", aliases: ["", ...], ...}
+// Replaced IDs:
+//    0: Retired Entity ID
+//    1: Entity ID that replaced it
+//    2: comma-separated list of "replaced_by" + Entity Type IDs it applies to
+//    3: {}
 //
+// Invalid rows fail the load with a *LoadError (see LoadWithMode).
 func Load(filename string) (Source, error) {
+	return LoadWithMode(filename, LoadStrict)
+}
+
+// LoadMode determines how invalid rows in a flat file are handled.
+type LoadMode int
+
+const (
+	// LoadStrict fails on the first invalid row with a *LoadError.
+	LoadStrict LoadMode = iota
+
+	// LoadLenient skips and counts invalid rows, and logs a summary.
+	LoadLenient
+)
+
+// maximum number of skipped rows to log individually in LoadLenient mode.
+const maxLoggedErrors = 10
+
+// maximum length of a line in a flat file.
+const maxLineLength = 64 * 1024 * 1024
+
+// LoadError describes an invalid row in a flat file.
+type LoadError struct {
+	// Filename of the flat file.
+	Filename string
+
+	// Line number (1-based) of the invalid row.
+	Line int
+
+	// Err describes the problem with the row.
+	Err error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("recongo.model: %s:%d: %s", e.Filename, e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// LoadWithMode loads a data source like Load, but in LoadLenient mode
+// invalid rows in flat files are skipped instead of failing the load.
+func LoadWithMode(filename string, mode LoadMode) (Source, error) {
+	if strings.HasPrefix(filename, "postgres://") || strings.HasPrefix(filename, "postgresql://") {
+		return dbOpen("postgres", filename)
+	}
 	if strings.Contains(filename, "sqlite") {
 		return dbOpen("sqlite3", filename)
 	}
@@ -41,108 +98,184 @@ func Load(filename string) (Source, error) {
 		entities:   make(map[string][]*Entity),
 		types:      make(map[string]*Type),
 		properties: make(map[string][]*Property),
+		replacedBy: make(map[EntityID]EntityID),
 	}
-	defaultType := ""
-	var s *bufio.Scanner
+	var r io.Reader = f
 	if strings.HasSuffix(filename, ".gz") {
 		fz, err := gzip.NewReader(f)
-		if err == nil {
-			s = bufio.NewScanner(fz)
-		} else {
-			log.Println(err)
-			s = bufio.NewScanner(f)
+		if err != nil {
+			return nil, &LoadError{Filename: filename, Line: 0, Err: err}
 		}
-	} else {
-		s = bufio.NewScanner(f)
+		defer fz.Close()
+		r = fz
 	}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+
+	defaultType := ""
+	lineno, skipped := 0, 0
 	for s.Scan() {
+		lineno++
 		row := strings.SplitN(s.Text(), "\t", 4)
-		if src.name == "" {
-			src.name = row[1]
-			src.identifierNamespace = row[0]
-			src.schemaNamespace = row[2]
-
-			if row[3] == "" {
-				defaultType = "item"
-			} else {
-				tx := []*Type{}
-				err = json.Unmarshal([]byte(row[3]), &tx)
-				if err != nil {
-					return nil, err
-				}
-				for _, x := range tx {
-					src.types[x.ID] = x
-				}
-				if len(tx) >= 1 {
-					defaultType = tx[0].ID
-					src.viewURL = tx[0].ViewURL
-				}
+		if lineno == 1 {
+			// the header is required no matter the mode
+			defaultType, err = src.parseHeader(row)
+			if err != nil {
+				return nil, &LoadError{Filename: filename, Line: lineno, Err: err}
 			}
 			continue
 		}
 
-		if len(row) != 4 || row[3] == "" {
-			panic("invalid input")
+		err = src.parseRow(row, defaultType)
+		if err != nil {
+			lerr := &LoadError{Filename: filename, Line: lineno, Err: err}
+			if mode == LoadStrict {
+				return nil, lerr
+			}
+			skipped++
+			if skipped <= maxLoggedErrors {
+				log.Println("skipping:", lerr)
+			}
 		}
+	}
+	if err = s.Err(); err != nil {
+		return nil, &LoadError{Filename: filename, Line: lineno + 1, Err: err}
+	}
+	if lineno == 0 {
+		return nil, &LoadError{Filename: filename, Line: 1, Err: errors.New("missing header")}
+	}
+	if skipped > 0 {
+		log.Printf("skipped %d invalid rows of %d in '%s'", skipped, lineno-1, filename)
+	}
+
+	src.resolveRetired()
+	src.index = newMemoryIndex(src.entities)
+	log.Printf("loaded %d entities from '%s'. ", len(src.entities), filename)
+	return src, nil
+}
+
+// parseHeader parses the flat file header row, and returns the default Type ID.
+func (src *MemorySource) parseHeader(row []string) (string, error) {
+	if len(row) != 4 {
+		return "", fmt.Errorf("header has %d columns, expected 4", len(row))
+	}
+	src.name = row[1]
+	src.identifierNamespace = row[0]
+	src.schemaNamespace = row[2]
 
-		props := make(map[string]interface{})
-		typeIDs := strings.Split(row[2], ",")
-		if typeIDs[0] == "" {
-			typeIDs[0] = defaultType
+	if row[3] == "" {
+		return "item", nil
+	}
+	tx := []*Type{}
+	err := json.Unmarshal([]byte(row[3]), &tx)
+	if err != nil {
+		return "", fmt.Errorf("invalid types: %s", err)
+	}
+	defaultType := ""
+	for _, x := range tx {
+		src.types[x.ID] = x
+	}
+	if len(tx) >= 1 {
+		defaultType = tx[0].ID
+		src.viewURL = tx[0].ViewURL
+	}
+	return defaultType, nil
+}
+
+// parseRow parses a property or entity row of a flat file.
+func (src *MemorySource) parseRow(row []string, defaultType string) error {
+	if len(row) != 4 {
+		return fmt.Errorf("row has %d columns, expected 4", len(row))
+	}
+	if row[0] == "" {
+		return errors.New("missing identifier")
+	}
+	if row[3] == "" {
+		return errors.New("missing properties (use {} for none)")
+	}
+
+	props := make(map[string]interface{})
+	typeIDs := strings.Split(row[2], ",")
+	if typeIDs[0] == "" {
+		typeIDs[0] = defaultType
+	}
+
+	if row[3] != "{}" {
+		err := json.Unmarshal([]byte(row[3]), &props)
+		if err != nil {
+			return fmt.Errorf("invalid properties: %s", err)
+		}
+	}
+	desc := ""
+	if d, ok := props["description"]; ok {
+		if desc, ok = d.(string); !ok {
+			return errors.New("description is not a string")
+		}
+	}
+	var aliases []string
+	if a, ok := props["aliases"]; ok {
+		if aliases, ok = aliasValues(a); !ok {
+			return errors.New("aliases are not strings")
 		}
+	}
 
-		if row[3] != "{}" {
-			err = json.Unmarshal([]byte(row[3]), &props)
-			if err != nil {
-				return nil, err
+	if len(typeIDs) > 1 {
+		isProp, isReplaced := false, false
+		for _, tid := range typeIDs {
+			switch tid {
+			case "property":
+				isProp = true
+			case "replaced_by":
+				isReplaced = true
 			}
 		}
-
-		if len(typeIDs) > 1 {
-			isProp := false
-			for _, tid := range typeIDs {
-				if tid == "property" {
-					isProp = true
-				}
+		if isReplaced {
+			if row[1] == "" {
+				return errors.New("missing replacement identifier")
 			}
-			if isProp {
-				p := &Property{
-					ID:   row[0],
-					Name: row[1],
-				}
-				if d, ok := props["description"]; ok {
-					p.Description = d.(string)
+			for _, etype := range typeIDs {
+				if etype == "replaced_by" {
+					continue
 				}
-				for _, etype := range typeIDs {
-					if etype == "property" {
-						continue
-					}
-					src.properties[etype] = append(src.properties[etype], p)
-				}
-				continue
+				src.replacedBy[EntityID(etype+":"+row[0])] = EntityID(etype + ":" + row[1])
 			}
+			return nil
 		}
-
-		e := &Entity{
-			ID:   EntityID(row[0]),
-			Name: row[1],
-		}
-		for i, tid := range typeIDs {
-			if i == 0 {
-				e.ID = EntityID(tid + ":" + row[0])
+		if isProp {
+			p := &Property{
+				ID:          row[0],
+				Name:        row[1],
+				Description: desc,
+			}
+			if vt, ok := props["type"].(string); ok {
+				p.ValueType = vt
 			}
-			e.Types = append(e.Types, src.types[tid])
+			for _, etype := range typeIDs {
+				if etype == "property" {
+					continue
+				}
+				src.properties[etype] = append(src.properties[etype], p)
+			}
+			return nil
 		}
+	}
 
-		if len(props) > 0 {
-			if d, ok := props["description"]; ok {
-				e.Description = d.(string)
-			}
-			e.Properties = props
+	e := &Entity{
+		ID:   EntityID(row[0]),
+		Name: row[1],
+	}
+	for i, tid := range typeIDs {
+		if i == 0 {
+			e.ID = EntityID(tid + ":" + row[0])
 		}
-		src.entities[row[0]] = append(src.entities[row[0]], e)
+		e.Types = append(e.Types, src.types[tid])
 	}
 
-	log.Printf("loaded %d entities from '%s'. ", len(src.entities), filename)
-	return src, nil
+	if len(props) > 0 {
+		e.Description = desc
+		e.Aliases = aliases
+		e.Properties = props
+	}
+	src.entities[row[0]] = append(src.entities[row[0]], e)
+	return nil
 }
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testHeader = "ncbi\tNCBI Gene\tncbi\t[{\"id\":\"gene\",\"name\":\"Gene\"}]\n"
+
+// writeFlatFile writes the lines to a temporary flat file.
+func writeFlatFile(t *testing.T, lines ...string) string {
+	dir, err := ioutil.TempDir("", "recongo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	fn := filepath.Join(dir, "genes.tsv")
+	if err = ioutil.WriteFile(fn, []byte(strings.Join(lines, "")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return fn
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		line  int
+		err   string
+		// number of entities loaded in LoadLenient mode, or -1 if the
+		// load fails in both modes
+		lenient int
+	}{
+		{"empty", nil, 1, "missing header", -1},
+		{"short header", []string{"ncbi\tNCBI Gene\n"}, 1, "header has 2 columns, expected 4", -1},
+		{"bad types", []string{"ncbi\tNCBI Gene\tncbi\t[{\"id\":\n"}, 1, "invalid types", -1},
+		{"short row", []string{testHeader,
+			"672\tBRCA1\tgene\t{}\n",
+			"675\tBRCA2\n",
+			"7157\tTP53\tgene\t{}\n",
+		}, 3, "row has 2 columns, expected 4", 2},
+		{"missing id", []string{testHeader,
+			"672\tBRCA1\tgene\t{}\n",
+			"\tBRCA2\tgene\t{}\n",
+		}, 3, "missing identifier", 1},
+		{"missing properties", []string{testHeader,
+			"672\tBRCA1\tgene\t\n",
+		}, 2, "missing properties", 0},
+		{"bad properties", []string{testHeader,
+			"672\tBRCA1\tgene\t{}\n",
+			"675\tBRCA2\tgene\t{}\n",
+			"7157\tTP53\tgene\t{\"aliases\": [\"P53\"\n",
+		}, 4, "invalid properties", 2},
+		{"bad description", []string{testHeader,
+			"672\tBRCA1\tgene\t{\"description\": 1}\n",
+			"675\tBRCA2\tgene\t{}\n",
+		}, 2, "description is not a string", 1},
+		{"bad aliases", []string{testHeader,
+			"672\tBRCA1\tgene\t{}\n",
+			"675\tBRCA2\tgene\t{\"aliases\": [1, 2]}\n",
+		}, 3, "aliases are not strings", 1},
+		// the first invalid row is reported, and every invalid row is skipped
+		{"several", []string{testHeader,
+			"672\tBRCA1\tgene\t{}\n",
+			"675\n",
+			"7157\tTP53\tgene\t{}\n",
+			"7158\tTP53BP1\tgene\n",
+		}, 3, "row has 1 columns", 2},
+	}
+	for _, tc := range tests {
+		fn := writeFlatFile(t, tc.lines...)
+
+		_, err := LoadWithMode(fn, LoadStrict)
+		var lerr *LoadError
+		if !errors.As(err, &lerr) {
+			t.Errorf("%s: strict error = %v, want a *LoadError", tc.name, err)
+			continue
+		}
+		if lerr.Filename != fn || lerr.Line != tc.line || !strings.Contains(lerr.Err.Error(), tc.err) {
+			t.Errorf("%s: strict error = %v, want line %d: %s", tc.name, err, tc.line, tc.err)
+		}
+
+		src, err := LoadWithMode(fn, LoadLenient)
+		if tc.lenient < 0 {
+			if !errors.As(err, &lerr) || lerr.Line != tc.line {
+				t.Errorf("%s: lenient error = %v, want line %d", tc.name, err, tc.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: lenient error = %v", tc.name, err)
+			continue
+		}
+		if n := len(src.(*MemorySource).entities); n != tc.lenient {
+			t.Errorf("%s: lenient loaded %d entities, want %d", tc.name, n, tc.lenient)
+		}
+	}
+}
+
+func TestLoadErrorString(t *testing.T) {
+	err := &LoadError{Filename: "genes.tsv", Line: 3, Err: errors.New("missing identifier")}
+	if got, want := err.Error(), "recongo.model: genes.tsv:3: missing identifier"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if errors.Unwrap(err) != err.Err {
+		t.Error("Unwrap() does not return the row error")
+	}
+}
@@ -1,9 +1,9 @@
 package model
 
 import (
+	"context"
 	"log"
 	"sort"
-	"strings"
 )
 
 // MemorySource represents a data source entirely in memory.
@@ -21,10 +21,23 @@ type MemorySource struct {
 
 	// maps from Entity Type ID to a Property list for all supported entity types.
 	properties map[string][]*Property
+
+	// maps from retired Entity ID to the Entity ID that replaced it.
+	replacedBy map[EntityID]EntityID
+
+	// maps from raw retired Entity ID to the current entities that replaced it.
+	retired map[string][]*Entity
+
+	// index of entity names and tokens, built once all entities are loaded.
+	index *memoryIndex
+
+	// matcher finds and scores fuzzy name matches (nil to disable).
+	matcher Matcher
 }
 
-// ensure it implements the interface
+// ensure it implements the interfaces
 var _ Source = &MemorySource{}
+var _ ContextSource = &MemorySource{}
 
 // Name of the data Source.
 func (s *MemorySource) Name() string {
@@ -59,92 +72,152 @@ func (s *MemorySource) Properties(typeID string) []*Property {
 	return res
 }
 
-// GetEntity returns the Entity matching the provided ID.
+// GetEntity returns the Entity matching the provided ID. Retired IDs
+// resolve to the current Entity that replaced them.
 func (s *MemorySource) GetEntity(entityID EntityID) (*Entity, bool) {
-	ents, ok := s.entities[entityID.ID()]
-	if len(ents) == 0 || !ok {
-		return nil, false
+	if e, ok := s.getCurrentEntity(entityID); ok {
+		return e, true
 	}
-	for _, e := range ents {
-		if e.ID == entityID {
-			return e, true
-		}
+	if e := findEntityType(s.retired[entityID.ID()], entityID.Type()); e != nil {
+		return e, true
 	}
 	return nil, false
 }
 
+// GetEntityContext returns the Entity matching the provided ID,
+// or ErrNotFound if there is none.
+func (s *MemorySource) GetEntityContext(ctx context.Context, entityID EntityID) (*Entity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	e, ok := s.GetEntity(entityID)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+// getCurrentEntity returns the Entity matching the provided ID under
+// any of its Types, without resolving retired IDs.
+func (s *MemorySource) getCurrentEntity(entityID EntityID) (*Entity, bool) {
+	e := findEntityType(s.entities[entityID.ID()], entityID.Type())
+	return e, e != nil
+}
+
+// resolveRetired resolves each retired Entity ID to the current Entity
+// that replaced it, following chains of replacements.
+func (s *MemorySource) resolveRetired() {
+	s.retired = make(map[string][]*Entity)
+	for oldID, newID := range s.replacedBy {
+		for i := 0; i < maxRedirects; i++ {
+			if e, ok := s.getCurrentEntity(newID); ok {
+				s.retired[oldID.ID()] = append(s.retired[oldID.ID()], e)
+				break
+			}
+			next, ok := s.replacedBy[newID]
+			if !ok {
+				break
+			}
+			newID = next
+		}
+	}
+}
+
 // Query entitities for a match.
 func (s *MemorySource) Query(q *QueryRequest) (*QueryResponse, error) {
+	return s.QueryContext(context.Background(), q)
+}
+
+// QueryContext queries entitities for a match.
+func (s *MemorySource) QueryContext(ctx context.Context, q *QueryRequest) (*QueryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	res := &QueryResponse{
 		ID: q.ID,
 	}
 	log.Println(q)
+	trace := traceFrom(ctx)
 
 	// fast-track exact ID matches
 	if ents, ok := s.entities[q.Text]; ok {
 		log.Println("one-shot:", ents)
-
-		for _, e := range ents {
-			res.Results = append(res.Results, &Candidate{
-				ID:    e.ID,
-				Name:  e.Name,
-				Types: e.Types,
-				Score: 100.0,
-				Match: true,
-			})
+		trace.Step("exact_id", "")
+		res.Results = exactCandidates(ctx, q, ents, "")
+		if len(res.Results) > 0 {
+			return res, nil
+		}
+	}
+	// then retired IDs, which redirect to the entities that replaced them
+	if ents, ok := s.retired[q.Text]; ok {
+		log.Println("redirect:", ents)
+		trace.Step("retired_id", "")
+		res.Results = exactCandidates(ctx, q, ents, q.Text)
+		if len(res.Results) > 0 {
+			return res, nil
 		}
-		return res, nil
 	}
 
-	low := strings.ToLower(q.Text)
-
-	for _, ents := range s.entities {
-		for _, e := range ents {
-			score := 0.0
-			if strings.ToLower(e.ID.ID()) == low {
-				score = 95.0
-			} else if strings.Contains(strings.ToLower(e.Name), low) {
-				// essentially recall since there's no mismatch to low
-				score = float64(len(low)*100) / float64(len(e.Name))
+	seen := make(map[*Entity]struct{})
+	addHits := func(hits []*Entity) {
+		for _, e := range hits {
+			if _, ok := seen[e]; ok {
+				continue
 			}
-			if e.ID == "4336" {
-				log.Println(e.ID, e.Name, low, score)
-			}
-			if q.Type != "" {
-				for _, et := range e.Types {
-					if et.ID == q.Type {
-						score += 10.0
-						break
-					}
-				}
+			seen[e] = struct{}{}
+			if c := s.scoreEntity(ctx, q, e); c != nil {
+				res.Results = append(res.Results, c)
 			}
+		}
+	}
 
-			// TODO: score properties also
+	// exact (case-insensitive) ID matches, then name token matches
+	trace.Step("index:lower_id", "")
+	addHits(s.index.ByLowerID(q.Text, s.entities))
+	trace.Step("index:tokens", "")
+	addHits(s.index.Search(q.Text, nil))
 
-			if score > 0.0 {
-				res.Results = append(res.Results, &Candidate{
-					ID:    e.ID,
-					Name:  e.Name,
-					Types: e.Types,
-					Score: score,
-					Match: score > 80.0,
-				})
-			}
-		}
+	// similar name tokens, only if there is no good hit already
+	if s.matcher != nil && !hasGoodHit(res.Results) {
+		trace.Step("index:fuzzy:"+s.matcher.Name(), "")
+		addHits(s.index.Search(q.Text, s.matcher))
 	}
 
-	sort.Slice(res.Results, func(i, j int) bool {
-		return res.Results[i].Score > res.Results[j].Score
-	})
 	if q.Limit == 0 {
 		q.Limit = 25
 	}
-	if len(res.Results) > q.Limit {
-		res.Results = res.Results[:q.Limit]
-	}
+	res.Results = rankCandidates(res.Results, q.Limit)
 	return res, nil
 }
 
+// scoreEntity returns a scored Candidate for the entity, or nil if it
+// does not have the query types or its score is 0.
+func (s *MemorySource) scoreEntity(ctx context.Context, q *QueryRequest, e *Entity) *Candidate {
+	trace := traceFrom(ctx)
+	c := &Candidate{
+		ID:    e.ID,
+		Name:  e.Name,
+		Types: e.Types,
+	}
+	trace.Consider(c, nil)
+
+	f := newFeatures(q, e.ID, e.Name, e.Aliases, s.matcher)
+	keep := f.scoreTypes(q, e.Types)
+	if len(q.Properties) > 0 {
+		f.scoreProperties(e.Properties, q.Properties)
+	}
+	f.Apply(q, c)
+	if !keep {
+		trace.Drop(c, typeMismatchReason(q))
+		return nil
+	}
+	if c.Score <= 0.0 {
+		trace.Drop(c, "score is 0")
+		return nil
+	}
+	return c
+}
+
 // QueryPrefix searches entitities for a prefix match.
 func (s *MemorySource) QueryPrefix(text string, limit int) []*Entity {
 	log.Println("prefix: ", text, limit)
@@ -154,27 +227,28 @@ func (s *MemorySource) QueryPrefix(text string, limit int) []*Entity {
 		return e
 	}
 
-	var result []*Entity
-	low := strings.ToLower(text)
-	for _, ents := range s.entities {
-		for _, e := range ents {
-			if strings.HasPrefix(strings.ToLower(e.Name), low) {
-				result = append(result, e)
-
-				if len(result) >= limit {
-					break
-				}
-			}
-		}
-	}
-
+	result := s.index.Prefix(text, limit)
 	sort.Slice(result, func(i, j int) bool {
 		return result[i].Name > result[j].Name
 	})
 	return result
 }
 
+// QueryPrefixContext searches entitities for a prefix match.
+func (s *MemorySource) QueryPrefixContext(ctx context.Context, text string, limit int) ([]*Entity, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.QueryPrefix(text, limit), nil
+}
+
 // ViewURL returns the template for a View URL.
 func (s *MemorySource) ViewURL() string {
 	return s.viewURL
 }
+
+// SetMatcher sets the fuzzy matching algorithm used to find and score
+// candidates. A nil Matcher disables fuzzy matching.
+func (s *MemorySource) SetMatcher(m Matcher) {
+	s.matcher = m
+}
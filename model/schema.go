@@ -0,0 +1,132 @@
+package model
+
+// SQLiteSchema creates the tables of a sqlite database source, as
+// written by data4recon. Requires the fts5 extension.
+var SQLiteSchema = []string{
+	`CREATE TABLE recongo_metadata (
+		meta_key varchar primary key,
+		meta_value varchar
+	);`,
+
+	`CREATE TABLE recongo_types (
+		type_id varchar primary key,
+		type_name varchar,
+		type_description varchar,
+		type_url varchar
+	);`,
+
+	`CREATE TABLE recongo_properties (
+		prop_id varchar primary key,
+		prop_name varchar,
+		prop_description varchar,
+		prop_type varchar -- str, int, float, bool, date, or a type_id
+	);`,
+
+	`CREATE TABLE recongo_props2types (
+		prop_id varchar references recongo_properties (prop_id),
+		type_id vachar references recongo_types (type_id),
+		primary key (prop_id, type_id)
+	);`,
+
+	`CREATE TABLE recongo_entities (
+		ent_types varchar, -- comma-separated list of type_ids
+		ent_id varchar,
+		ent_name varchar,
+		ent_description varchar,
+		ent_aliases varchar, -- newline-separated list of aliases
+		primary key(ent_id, ent_types)
+	);`,
+
+	`CREATE TABLE recongo_entity_properties (
+		ent_types varchar, -- the first type_id of the entity
+		ent_id varchar,
+		prop_id varchar,
+		prop_value varchar,
+		primary key (ent_types,ent_id,prop_id,prop_value)
+	)`,
+
+	`CREATE VIRTUAL TABLE recongo_entities_fts USING fts5
+		(ent_id, ent_name, ent_aliases, ent_description, ent_types, content=recongo_entities);`,
+
+	// index terms, used to find fuzzy matches
+	`CREATE VIRTUAL TABLE recongo_entities_vocab USING fts5vocab
+		(recongo_entities_fts, row);`,
+
+	// retired entity ids and the ids that replaced them
+	`CREATE TABLE recongo_replaced (
+		type_id varchar,
+		old_id varchar,
+		new_id varchar,
+		primary key (old_id, type_id)
+	);`,
+}
+
+// SQLiteIndexes are run after loading the tables of a sqlite database
+// source, to build the full-text index of the entities.
+var SQLiteIndexes = []string{
+	`INSERT INTO recongo_entities_fts(recongo_entities_fts) VALUES ('rebuild');`,
+}
+
+// PostgresSchema creates the tables of a postgres database source, as
+// written by data4recon. Requires the pg_trgm extension.
+var PostgresSchema = []string{
+	`CREATE TABLE recongo_metadata (
+		meta_key varchar primary key,
+		meta_value varchar
+	);`,
+
+	`CREATE TABLE recongo_types (
+		type_id varchar primary key,
+		type_name varchar,
+		type_description varchar,
+		type_url varchar
+	);`,
+
+	`CREATE TABLE recongo_properties (
+		prop_id varchar primary key,
+		prop_name varchar,
+		prop_description varchar,
+		prop_type varchar -- str, int, float, bool, date, or a type_id
+	);`,
+
+	`CREATE TABLE recongo_props2types (
+		prop_id varchar references recongo_properties (prop_id),
+		type_id varchar references recongo_types (type_id),
+		primary key (prop_id, type_id)
+	);`,
+
+	`CREATE TABLE recongo_entities (
+		ent_types varchar, -- comma-separated list of type_ids
+		ent_id varchar,
+		ent_name varchar,
+		ent_description varchar,
+		ent_aliases varchar, -- newline-separated list of aliases
+		ent_tsv tsvector GENERATED ALWAYS AS (to_tsvector('simple',
+			coalesce(ent_id,'') || ' ' || coalesce(ent_name,'') || ' ' ||
+			coalesce(ent_aliases,'') || ' ' || coalesce(ent_description,''))) STORED,
+		primary key(ent_id, ent_types)
+	);`,
+
+	`CREATE TABLE recongo_entity_properties (
+		ent_types varchar, -- the first type_id of the entity
+		ent_id varchar,
+		prop_id varchar,
+		prop_value varchar,
+		primary key (ent_types,ent_id,prop_id,prop_value)
+	);`,
+
+	`CREATE TABLE recongo_replaced (
+		type_id varchar,
+		old_id varchar,
+		new_id varchar,
+		primary key (old_id, type_id)
+	);`,
+}
+
+// PostgresIndexes are created after loading the tables of a postgres
+// database source, which is faster than updating them for each row.
+var PostgresIndexes = []string{
+	`CREATE INDEX recongo_entities_tsv ON recongo_entities USING gin (ent_tsv);`,
+	`CREATE INDEX recongo_entities_trgm ON recongo_entities USING gin (ent_name gin_trgm_ops);`,
+	`ANALYZE;`,
+}
@@ -0,0 +1,290 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Scores are the probability (from 0 to 100) that a candidate is the
+// right one. The name similarity is taken as the probability before
+// any other evidence, and the odds are then multiplied by a likelihood
+// ratio for each piece of type and property evidence. With no other
+// evidence the score is the name similarity, on the same scale for
+// every source and query.
+const (
+	// matchThreshold is the score the top candidate must reach to be a Match.
+	matchThreshold = 80.0
+
+	// matchMargin is how far the top candidate's score must be above the
+	// next candidate's to be a Match, so that ambiguous names never match.
+	matchMargin = 10.0
+
+	// maxNameProbability caps the probability from an exact name match,
+	// so that property evidence can still move its score.
+	maxNameProbability = 0.99
+
+	// propertyMatchRatio multiplies the odds of a candidate for each
+	// query property value that agrees with the entity.
+	propertyMatchRatio = 4.0
+
+	// propertyMismatchRatio multiplies the odds of a candidate for each
+	// query property value that the entity has a different value for.
+	propertyMismatchRatio = 0.02
+
+	// typeMissRatio multiplies the odds of a candidate that does not
+	// have the query Types when the query's type_strict is "should"
+	// (scaled by the fraction of the Types it does not have).
+	typeMissRatio = 0.5
+)
+
+// Feature IDs of the features a candidate's score is computed from.
+const (
+	// FeatureIDExact is true if the query text is the candidate's ID.
+	FeatureIDExact = "id_exact"
+
+	// FeatureNameSimilarity is how well the query text matches the
+	// candidate's name or aliases, from 0 to 1.
+	FeatureNameSimilarity = "name_similarity"
+
+	// FeatureTypeMatch is the fraction of the query Types that the
+	// candidate has (only when the query has Types).
+	FeatureTypeMatch = "type_match"
+
+	// FeaturePropertyAgreement is the number of query property values
+	// that agree with the candidate less those that disagree, divided
+	// by the number compared, from -1 to 1 (only when the query has
+	// properties the candidate has).
+	FeaturePropertyAgreement = "property_agreement"
+)
+
+// matchFeatures are the named features of a candidate for a query.
+type matchFeatures struct {
+	idExact        bool
+	nameSimilarity float64
+
+	// alias is the alias that the name similarity is for (if any).
+	alias string
+
+	// typeMatch is only used if hasTypes is true.
+	hasTypes  bool
+	typeMatch float64
+
+	// number of query property values that agree or disagree.
+	propMatches, propMismatches int
+}
+
+// newFeatures computes the ID and name features of an entity for a query.
+func newFeatures(q *QueryRequest, id EntityID, name string, aliases []string, m Matcher) *matchFeatures {
+	f := &matchFeatures{}
+	if id.ID() == q.Text || strings.EqualFold(id.ID(), q.Text) {
+		f.idExact = true
+	}
+	f.nameSimilarity, f.alias = nameSimilarity(strings.ToLower(q.Text), name, aliases, m)
+	return f
+}
+
+// Score combines the features into a calibrated score from 0 to 100.
+// Exact ID matches always score 100, and candidates without any name
+// similarity score 0.
+func (f *matchFeatures) Score(q *QueryRequest) float64 {
+	if f.idExact {
+		return 100.0
+	}
+	p := f.nameSimilarity
+	if p <= 0.0 {
+		return 0.0
+	}
+	if p > maxNameProbability {
+		p = maxNameProbability
+	}
+
+	logOdds := math.Log(p / (1.0 - p))
+	if f.hasTypes && q.Strictness == "should" {
+		logOdds += (1.0 - f.typeMatch) * math.Log(typeMissRatio)
+	}
+	logOdds += float64(f.propMatches)*math.Log(propertyMatchRatio) +
+		float64(f.propMismatches)*math.Log(propertyMismatchRatio)
+
+	score := 100.0 / (1.0 + math.Exp(-logOdds))
+	return math.Round(score*100.0) / 100.0
+}
+
+// List returns the features in the form of the reconciliation spec.
+func (f *matchFeatures) List() []*Feature {
+	res := []*Feature{
+		{ID: FeatureIDExact, Value: f.idExact},
+		{ID: FeatureNameSimilarity, Value: roundFeature(f.nameSimilarity)},
+	}
+	if f.hasTypes {
+		res = append(res, &Feature{ID: FeatureTypeMatch, Value: roundFeature(f.typeMatch)})
+	}
+	if n := f.propMatches + f.propMismatches; n > 0 {
+		res = append(res, &Feature{ID: FeaturePropertyAgreement,
+			Value: roundFeature(float64(f.propMatches-f.propMismatches) / float64(n))})
+	}
+	return res
+}
+
+// roundFeature rounds a feature value to 4 decimal places.
+func roundFeature(v float64) float64 {
+	return math.Round(v*10000.0) / 10000.0
+}
+
+// Apply sets the score and features of the candidate. Matches are set
+// once all the candidates are ranked, by markMatch.
+func (f *matchFeatures) Apply(q *QueryRequest, c *Candidate) {
+	c.Score = f.Score(q)
+	c.MatchedAlias = f.alias
+	c.Features = f.List()
+}
+
+// markMatch sets Match on the top candidate of results sorted by score,
+// only if it reaches matchThreshold and is matchMargin above the next
+// candidate. No other candidate is a Match.
+func markMatch(results []*Candidate) {
+	for _, c := range results {
+		c.Match = false
+	}
+	if len(results) == 0 || results[0].Score < matchThreshold {
+		return
+	}
+	if len(results) > 1 && results[0].Score-results[1].Score < matchMargin {
+		return
+	}
+	results[0].Match = true
+}
+
+// hasGoodHit returns true if any of the candidates reaches matchThreshold,
+// in which case fuzzy matching is not needed to find more.
+func hasGoodHit(results []*Candidate) bool {
+	for _, c := range results {
+		if c.Score >= matchThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// rankCandidates sorts candidates by score, marks the Match (if any), and
+// returns up to limit of them.
+func rankCandidates(results []*Candidate, limit int) []*Candidate {
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	markMatch(results)
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// scoreTypes applies the query Type IDs and type_strict to the Types of
+// a candidate. It returns false if the candidate should be dropped, and
+// otherwise sets the type match feature.
+func (f *matchFeatures) scoreTypes(q *QueryRequest, types []*Type) bool {
+	frac, keep := matchTypes(q, types)
+	f.hasTypes = len(q.Type) > 0
+	f.typeMatch = frac
+	return keep
+}
+
+// matchTypes applies the query Type IDs and type_strict to the Types of
+// a candidate. It returns false if the candidate should be dropped, and
+// otherwise the fraction of query Types that the candidate has.
+func matchTypes(q *QueryRequest, types []*Type) (float64, bool) {
+	if len(q.Type) == 0 {
+		return 0.0, true
+	}
+	hits := 0
+	for _, tid := range q.Type {
+		for _, t := range types {
+			if t != nil && t.ID == tid {
+				hits++
+				break
+			}
+		}
+	}
+	frac := float64(hits) / float64(len(q.Type))
+
+	switch q.Strictness {
+	case "should":
+		return frac, true
+	case "all":
+		return frac, hits == len(q.Type)
+	default:
+		// "any"
+		return frac, hits > 0
+	}
+}
+
+// scoreProperties sets the property agreement features given by the
+// evidence of the query property values against an entity's properties.
+// Properties the entity does not have do not count for or against it.
+func (f *matchFeatures) scoreProperties(props map[string]interface{}, qprops []*QueryProperty) {
+	for _, qp := range qprops {
+		ev, ok := props[qp.ID]
+		if !ok {
+			continue
+		}
+		if propertyMatches(ev, qp.Value) {
+			f.propMatches++
+		} else {
+			f.propMismatches++
+		}
+	}
+}
+
+// propertyMatches returns true if any of the entity's values for a
+// property agrees with any of the query's values for it.
+func propertyMatches(entityValue, queryValue interface{}) bool {
+	evs := normalizeValues(entityValue, nil)
+	for _, qv := range normalizeValues(queryValue, nil) {
+		for _, ev := range evs {
+			if qv == ev {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeValues appends comparable lowercase strings for a property
+// value to dst. Lists are flattened, and entity references {"id": ...}
+// are represented by both their full and type-specific IDs.
+func normalizeValues(v interface{}, dst []string) []string {
+	switch x := v.(type) {
+	case nil:
+		return dst
+	case []interface{}:
+		for _, y := range x {
+			dst = normalizeValues(y, dst)
+		}
+		return dst
+	case []string:
+		for _, y := range x {
+			dst = normalizeValues(y, dst)
+		}
+		return dst
+	case map[string]interface{}:
+		id, ok := x["id"].(string)
+		if !ok {
+			return dst
+		}
+		dst = normalizeValues(id, dst)
+		if eid := EntityID(id); eid.Type() != "" {
+			dst = normalizeValues(eid.ID(), dst)
+		}
+		return dst
+	case Entity:
+		return normalizeValues(map[string]interface{}{"id": string(x.ID)}, dst)
+	case PropertyValue:
+		return normalizeValues(x.v, dst)
+	case string:
+		return append(dst, strings.ToLower(strings.TrimSpace(x)))
+	default:
+		// bool, int64, float64
+		return append(dst, strings.ToLower(fmt.Sprint(x)))
+	}
+}
@@ -0,0 +1,327 @@
+package model
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var brca1 = &Entity{
+	ID:      "gene:672",
+	Name:    "BRCA1",
+	Aliases: []string{"BRCAI", "BRCC1", "IRIS"},
+	Types:   []*Type{geneType},
+	Properties: map[string]interface{}{
+		"tax_id":     "9606",
+		"chromosome": "17",
+	},
+}
+
+// scoreFor returns the calibrated score of an entity for a query.
+func scoreFor(q *QueryRequest, e *Entity, m Matcher) float64 {
+	f := newFeatures(q, e.ID, e.Name, e.Aliases, m)
+	f.scoreTypes(q, e.Types)
+	f.scoreProperties(e.Properties, q.Properties)
+	return f.Score(q)
+}
+
+func TestScore(t *testing.T) {
+	human := []*QueryProperty{{ID: "tax_id", Value: "9606"}}
+	mouse := []*QueryProperty{{ID: "tax_id", Value: "10090"}}
+	tests := []struct {
+		name string
+		q    *QueryRequest
+		m    Matcher
+		want float64
+	}{
+		{"exact id", &QueryRequest{Text: "672"}, nil, 100},
+		{"exact id contradicted", &QueryRequest{Text: "672", Properties: mouse}, nil, 100},
+		{"exact name", &QueryRequest{Text: "brca1"}, nil, 99},
+		{"exact alias", &QueryRequest{Text: "IRIS"}, nil, 90},
+		{"partial name", &QueryRequest{Text: "brca"}, nil, 80},
+		{"partial alias", &QueryRequest{Text: "brc"}, nil, 60},
+		{"fuzzy name", &QueryRequest{Text: "brca2"}, Levenshtein{}, 80},
+		{"fuzzy alias", &QueryRequest{Text: "brac1"}, Levenshtein{}, 72}, // BRCC1
+		{"fuzzy without matcher", &QueryRequest{Text: "brac1"}, nil, 0},
+		{"no similarity", &QueryRequest{Text: "tp53"}, nil, 0},
+
+		// property evidence multiplies the odds
+		{"exact name agreed", &QueryRequest{Text: "brca1", Properties: human}, nil, 99.75},
+		{"exact name contradicted", &QueryRequest{Text: "brca1", Properties: mouse}, nil, 66.44},
+		{"partial name agreed", &QueryRequest{Text: "brca", Properties: human}, nil, 94.12},
+		{"partial name contradicted", &QueryRequest{Text: "brca", Properties: mouse}, nil, 7.41},
+		{"exact name mixed", &QueryRequest{Text: "brca1", Properties: []*QueryProperty{
+			{ID: "tax_id", Value: "9606"}, {ID: "chromosome", Value: "13"}}}, nil, 88.79},
+		{"unknown property", &QueryRequest{Text: "brca1", Properties: []*QueryProperty{
+			{ID: "map_location", Value: "17q21"}}}, nil, 99},
+
+		// only "should" changes the score for the types
+		{"should have type", &QueryRequest{Text: "brca1", Type: TypeIDs{"gene"}, Strictness: "should"}, nil, 99},
+		{"should miss type", &QueryRequest{Text: "brca1", Type: TypeIDs{"protein"}, Strictness: "should"}, nil, 98.02},
+		{"any has type", &QueryRequest{Text: "brca1", Type: TypeIDs{"gene"}}, nil, 99},
+	}
+	for _, tc := range tests {
+		if got := scoreFor(tc.q, brca1, tc.m); got != tc.want {
+			t.Errorf("%s: score for %q = %v, want %v", tc.name, tc.q.Text, got, tc.want)
+		}
+	}
+}
+
+func TestScoreWithoutEvidenceIsNameSimilarity(t *testing.T) {
+	for _, sim := range []float64{0.1, 0.35, 0.5, 0.8, 0.9} {
+		f := &matchFeatures{nameSimilarity: sim}
+		if got := f.Score(&QueryRequest{}); got != sim*100 {
+			t.Errorf("score for similarity %v = %v, want %v", sim, got, sim*100)
+		}
+	}
+}
+
+func TestApplyFeatures(t *testing.T) {
+	q := &QueryRequest{Text: "iris", Type: TypeIDs{"gene"},
+		Properties: []*QueryProperty{{ID: "tax_id", Value: "9606"}}}
+	f := newFeatures(q, brca1.ID, brca1.Name, brca1.Aliases, nil)
+	f.scoreTypes(q, brca1.Types)
+	f.scoreProperties(brca1.Properties, q.Properties)
+	c := &Candidate{ID: brca1.ID, Name: brca1.Name}
+	f.Apply(q, c)
+
+	if c.Score != 97.3 {
+		t.Errorf("score = %v, want 97.3", c.Score)
+	}
+	if c.MatchedAlias != "IRIS" {
+		t.Errorf("matched alias = %q, want IRIS", c.MatchedAlias)
+	}
+	want := map[string]interface{}{
+		FeatureIDExact:           false,
+		FeatureNameSimilarity:    0.9,
+		FeatureTypeMatch:         1.0,
+		FeaturePropertyAgreement: 1.0,
+	}
+	if len(c.Features) != len(want) {
+		t.Fatalf("features = %v, want %v", c.Features, want)
+	}
+	for _, ft := range c.Features {
+		if ft.Value != want[ft.ID] {
+			t.Errorf("feature %s = %v, want %v", ft.ID, ft.Value, want[ft.ID])
+		}
+	}
+}
+
+func TestMarkMatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		scores []float64
+		want   []bool
+	}{
+		{"unique top", []float64{99, 60}, []bool{true, false}},
+		{"single", []float64{85}, []bool{true}},
+		{"tied", []float64{99, 99}, []bool{false, false}},
+		{"within margin", []float64{95, 86}, []bool{false, false}},
+		{"at margin", []float64{95, 85}, []bool{true, false}},
+		{"below threshold", []float64{79.99}, []bool{false}},
+		{"at threshold", []float64{80, 20}, []bool{true, false}},
+		{"none", nil, nil},
+	}
+	for _, tc := range tests {
+		var cs []*Candidate
+		for _, s := range tc.scores {
+			cs = append(cs, &Candidate{Score: s, Match: true})
+		}
+		markMatch(cs)
+		for i, c := range cs {
+			if c.Match != tc.want[i] {
+				t.Errorf("%s: candidate %d match = %v, want %v", tc.name, i, c.Match, tc.want[i])
+			}
+		}
+	}
+}
+
+// Two genes with the same symbol are ambiguous, unless a property
+// tells them apart.
+func TestQueryMatchIsUnique(t *testing.T) {
+	mouse := &Entity{ID: "gene:12189", Name: "Brca1", Types: []*Type{geneType},
+		Properties: map[string]interface{}{"tax_id": "10090"}}
+	src := newTestMemorySource(brca1, mouse)
+
+	res, err := src.Query(&QueryRequest{Text: "BRCA1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Results) != 2 || res.Results[0].Match || res.Results[1].Match {
+		t.Errorf("ambiguous query: got %v, want 2 candidates without a match", res.Results)
+	}
+
+	res, err = src.Query(&QueryRequest{Text: "BRCA1",
+		Properties: []*QueryProperty{{ID: "tax_id", Value: "9606"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Results) != 2 || res.Results[0].ID != brca1.ID || !res.Results[0].Match {
+		t.Errorf("query with tax_id: got %v, want a match for %s", res.Results, brca1.ID)
+	}
+}
+
+// newTestMemorySource returns a MemorySource with the given entities.
+func newTestMemorySource(ents ...*Entity) *MemorySource {
+	src := &MemorySource{
+		name:       "test",
+		entities:   make(map[string][]*Entity),
+		types:      make(map[string]*Type),
+		properties: make(map[string][]*Property),
+	}
+	for _, e := range ents {
+		src.entities[e.ID.ID()] = append(src.entities[e.ID.ID()], e)
+		for _, t := range e.Types {
+			src.types[t.ID] = t
+		}
+	}
+	src.resolveRetired()
+	src.index = newMemoryIndex(src.entities)
+	return src
+}
+
+func TestPropertyMatches(t *testing.T) {
+	tests := []struct {
+		ev, qv interface{}
+		want   bool
+	}{
+		{"9606", "9606", true},
+		{"9606", "10090", false},
+		// case and surrounding space are ignored
+		{"Homo sapiens", " homo SAPIENS ", true},
+		// lists match if any values agree
+		{[]interface{}{"17", "17q21.31"}, "17q21.31", true},
+		{[]string{"BRCAI", "IRIS"}, []interface{}{"p53", "iris"}, true},
+		{[]string{"BRCAI", "IRIS"}, []interface{}{"p53"}, false},
+		// numbers and bools compare as text
+		{"9606", int64(9606), true},
+		{int64(9606), 9606.0, true},
+		{true, "TRUE", true},
+		// entity references match by full or type-specific ID
+		{"gene:672", map[string]interface{}{"id": "gene:672", "name": "BRCA1"}, true},
+		{"672", map[string]interface{}{"id": "gene:672"}, true},
+		{Entity{ID: "gene:672"}, "672", true},
+		{map[string]interface{}{"name": "BRCA1"}, "BRCA1", false},
+		{NewPropertyValue("17"), "17", true},
+		{nil, "9606", false},
+		{"9606", nil, false},
+	}
+	for _, tc := range tests {
+		if got := propertyMatches(tc.ev, tc.qv); got != tc.want {
+			t.Errorf("propertyMatches(%#v, %#v) = %v, want %v", tc.ev, tc.qv, got, tc.want)
+		}
+	}
+}
+
+func TestScoreProperties(t *testing.T) {
+	props := map[string]interface{}{
+		"tax_id":     "9606",
+		"chromosome": "17",
+		"synonyms":   []interface{}{"BRCAI", "IRIS"},
+	}
+	tests := []struct {
+		name              string
+		qprops            []*QueryProperty
+		matches, mismatch int
+		agreement         interface{}
+	}{
+		{"none", nil, 0, 0, nil},
+		{"agree", []*QueryProperty{{ID: "tax_id", Value: "9606"}}, 1, 0, 1.0},
+		{"disagree", []*QueryProperty{{ID: "tax_id", Value: "10090"}}, 0, 1, -1.0},
+		{"mixed", []*QueryProperty{
+			{ID: "tax_id", Value: "9606"},
+			{ID: "chromosome", Value: "13"},
+			{ID: "synonyms", Value: "iris"},
+		}, 2, 1, 0.3333},
+		// properties the entity does not have are not evidence
+		{"unknown", []*QueryProperty{{ID: "map_location", Value: "17q21"}}, 0, 0, nil},
+	}
+	for _, tc := range tests {
+		f := &matchFeatures{nameSimilarity: 1.0}
+		f.scoreProperties(props, tc.qprops)
+		if f.propMatches != tc.matches || f.propMismatches != tc.mismatch {
+			t.Errorf("%s: %d matches, %d mismatches, want %d, %d",
+				tc.name, f.propMatches, f.propMismatches, tc.matches, tc.mismatch)
+		}
+		var agreement interface{}
+		for _, ft := range f.List() {
+			if ft.ID == FeaturePropertyAgreement {
+				agreement = ft.Value
+			}
+		}
+		if agreement != tc.agreement {
+			t.Errorf("%s: property agreement = %v, want %v", tc.name, agreement, tc.agreement)
+		}
+	}
+}
+
+func TestMatchTypes(t *testing.T) {
+	protein := proteinType
+	both := []*Type{geneType, protein}
+	tests := []struct {
+		strict string
+		qtypes TypeIDs
+		types  []*Type
+		frac   float64
+		keep   bool
+	}{
+		// no query types keep every candidate
+		{"", nil, nil, 0, true},
+		{"all", nil, []*Type{geneType}, 0, true},
+
+		// "any" (the default) needs one of the types
+		{"", TypeIDs{"gene"}, []*Type{geneType}, 1, true},
+		{"any", TypeIDs{"gene"}, []*Type{protein}, 0, false},
+		{"any", TypeIDs{"gene", "protein"}, []*Type{geneType}, 0.5, true},
+		{"any", TypeIDs{"gene"}, nil, 0, false},
+
+		// "all" needs every type
+		{"all", TypeIDs{"gene", "protein"}, both, 1, true},
+		{"all", TypeIDs{"gene", "protein"}, []*Type{geneType}, 0.5, false},
+
+		// "should" keeps every candidate, with the fraction of types it has
+		{"should", TypeIDs{"gene", "protein"}, []*Type{protein}, 0.5, true},
+		{"should", TypeIDs{"gene"}, nil, 0, true},
+
+		// unknown types (nil) in a candidate are ignored
+		{"any", TypeIDs{"gene"}, []*Type{nil, geneType}, 1, true},
+	}
+	for _, tc := range tests {
+		frac, keep := matchTypes(&QueryRequest{Type: tc.qtypes, Strictness: tc.strict}, tc.types)
+		if frac != tc.frac || keep != tc.keep {
+			t.Errorf("matchTypes(%s %v, %v) = %v, %v, want %v, %v",
+				tc.strict, []string(tc.qtypes), tc.types, frac, keep, tc.frac, tc.keep)
+		}
+	}
+}
+
+// Candidates without the query types are dropped, unless the types are
+// only preferred.
+func TestQueryTypes(t *testing.T) {
+	src := newTestMemorySource(brca1,
+		&Entity{ID: "protein:P38398", Name: "BRCA1", Types: []*Type{proteinType}})
+	tests := []struct {
+		strict string
+		qtypes TypeIDs
+		want   []string
+	}{
+		// both candidates have the same score, in any order
+		{"", nil, []string{"gene:672", "protein:P38398"}},
+		{"any", TypeIDs{"gene"}, []string{"gene:672"}},
+		{"all", TypeIDs{"gene", "protein"}, nil},
+		{"should", TypeIDs{"protein"}, []string{"protein:P38398", "gene:672"}},
+	}
+	for _, tc := range tests {
+		res, err := src.Query(&QueryRequest{Text: "BRCA1", Type: tc.qtypes, Strictness: tc.strict})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := candidateIDs(res.Results)
+		if tc.qtypes == nil {
+			sort.Strings(got)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s %v: candidates = %v, want %v", tc.strict, []string(tc.qtypes), got, tc.want)
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package model
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
 // Source represents a data source.
 type Source interface {
 	// Name of the data Source.
@@ -30,6 +36,41 @@ type Source interface {
 	QueryPrefix(text string, limit int) []*Entity
 }
 
+// ErrNotFound is returned when a requested Entity does not exist.
+var ErrNotFound = errors.New("recongo.model: entity not found")
+
+// ContextSource represents a data source whose lookups can be cancelled
+// and report errors. Use WithContext to adapt any Source.
+type ContextSource interface {
+	// Name of the data Source.
+	Name() string
+
+	// IdentifierNS is a universal namespace for Entity identifiers.
+	IdentifierNS() string
+
+	// SchemaNS is a universal namespace for concept Type identifiers.
+	SchemaNS() string
+
+	// ViewURL returns the template for a View URL.
+	ViewURL() string
+
+	// Types returns all supported Entity types.
+	Types() []*Type
+
+	// Properties returns all supported Properties for Entities with the Type ID given.
+	Properties(typeID string) []*Property
+
+	// GetEntityContext returns the Entity matching the provided ID,
+	// or ErrNotFound if there is none.
+	GetEntityContext(ctx context.Context, entityID EntityID) (*Entity, error)
+
+	// QueryContext queries entitities for a match.
+	QueryContext(ctx context.Context, q *QueryRequest) (*QueryResponse, error)
+
+	// QueryPrefixContext searches entitities for a prefix match.
+	QueryPrefixContext(ctx context.Context, text string, limit int) ([]*Entity, error)
+}
+
 // QueryRequest describes a Reconciliation Query request.
 type QueryRequest struct {
 	// ID to refer to the query.
@@ -38,8 +79,8 @@ type QueryRequest struct {
 	// Text is the search text to query for.
 	Text string `json:"query"`
 
-	// Type is the Type ID to query over (if present).
-	Type string `json:"type"`
+	// Type lists the Type IDs to query over (if present).
+	Type TypeIDs `json:"type"`
 
 	// Limit the results to the first N results.
 	Limit int `json:"limit"`
@@ -48,9 +89,49 @@ type QueryRequest struct {
 	Properties []*QueryProperty `json:"properties"`
 
 	// Strictness should be set to "any", "all", or "should"
+	//   any: candidates must have at least one of the query Types (default)
+	//   all: candidates must have all of the query Types
+	//   should: candidates with a query Type are preferred, but none are dropped
 	Strictness string `json:"type_strict,omitempty"`
 }
 
+// TypeIDs is a list of Type IDs, which can be given in JSON as
+// a single string or a list of strings.
+type TypeIDs []string
+
+// UnmarshalJSON implements the json.Unmarshaler interface, so that
+// both a single Type ID and a list of Type IDs can be used.
+func (t *TypeIDs) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		if one == "" {
+			*t = nil
+		} else {
+			*t = TypeIDs{one}
+		}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*t = TypeIDs(many)
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface, so that a
+// single Type ID is represented as a string.
+func (t TypeIDs) MarshalJSON() ([]byte, error) {
+	switch len(t) {
+	case 0:
+		return json.Marshal("")
+	case 1:
+		return json.Marshal(t[0])
+	default:
+		return json.Marshal([]string(t))
+	}
+}
+
 // QueryProperty depicts a query against a property value.
 type QueryProperty struct {
 	// ID is the property ID.
@@ -69,6 +150,15 @@ type QueryResponse struct {
 	Results []*Candidate `json:"result"`
 }
 
+// Feature is a named value describing how well a Candidate matches a query.
+type Feature struct {
+	// ID of the feature, e.g. "name_similarity".
+	ID string `json:"id"`
+
+	// Value of the feature, a number or a boolean.
+	Value interface{} `json:"value"`
+}
+
 // Candidate describes a Reconciliation Query candidate entity.
 type Candidate struct {
 	// ID of the candidate entity.
@@ -80,9 +170,23 @@ type Candidate struct {
 	// Types of the candidate entity.
 	Types []*Type `json:"type"`
 
-	// Score indicates how good the match is. Higher is better.
+	// Score indicates how good the match is, from 0 to 100. Higher is better.
 	Score float64 `json:"score"`
 
 	// Match indicates if this is a "good" match or not.
 	Match bool `json:"match"`
+
+	// Features lists the named features that the Score was computed from.
+	Features []*Feature `json:"features,omitempty"`
+
+	// MatchedAlias is the alias of the entity that the query matched,
+	// if it matched an alias instead of the name.
+	MatchedAlias string `json:"matched_alias,omitempty"`
+
+	// Redirect indicates that the query matched a retired or merged
+	// Entity ID, and this candidate is the Entity that replaced it.
+	Redirect bool `json:"redirect,omitempty"`
+
+	// RedirectedFrom is the retired Entity ID that was matched.
+	RedirectedFrom EntityID `json:"redirected_from,omitempty"`
 }
@@ -38,7 +38,11 @@ type Entity struct {
 	// Description is a human-readable description of the entity.
 	Description string `json:"description,omitempty"`
 
+	// Aliases are alternative names (synonyms) for the entity.
+	Aliases []string `json:"-"`
+
 	// Properties is all the other properties of the Entity.
+	// Multi-valued properties have a []interface{} list of values.
 	Properties map[string]interface{} `json:"-"`
 
 	// Types is a (possibly empty) list of entity types for this entity.
@@ -72,10 +76,20 @@ type Property struct {
 	// Description is a human-readable description of the property.
 	Description string `json:"description,omitempty"`
 
-	// ValueType expected for Property values.
+	// ValueType expected for Property values. One of the Value* constants,
+	// or the ID of an Entity Type for values that reference other entities.
 	ValueType string `json:"-"`
 }
 
+// Property value types for Property.ValueType.
+const (
+	ValueString = "str"
+	ValueInt    = "int"
+	ValueFloat  = "float"
+	ValueBool   = "bool"
+	ValueDate   = "date"
+)
+
 // PropertyValue is a specific value associated to an entity property.
 // In this implementation, the value MUST be one of the following Go types:
 //   string, bool, int64, float64, or Entity
@@ -83,6 +97,30 @@ type PropertyValue struct {
 	v interface{}
 }
 
+// PropertyValues returns the list of values for a property value
+// from Entity.Properties, whether it is multi-valued or not.
+func PropertyValues(v interface{}) []interface{} {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return x
+	case []string:
+		res := make([]interface{}, len(x))
+		for i, y := range x {
+			res[i] = y
+		}
+		return res
+	default:
+		return []interface{}{v}
+	}
+}
+
+// NewPropertyValue wraps a value for coercion into other types.
+func NewPropertyValue(v interface{}) PropertyValue {
+	return PropertyValue{v: v}
+}
+
 // String coerces the value into a string no matter what.
 // For Entity values, returns the ID.
 func (p PropertyValue) String() string {